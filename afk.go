@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+func afkPeriods(bucketsList Buckets, bucketEvents map[string][]Event) map[string][]Period {
+	periods := make(map[string][]Period)
+	for _, entry := range bucketsList {
+		if entry.Type != afkType {
+			continue
+		}
+		for _, event := range bucketEvents[entry.ID] {
+			data := new(AfkStatus)
+			err := json.Unmarshal(event.Data, data)
+			if err != nil || data.Status != "afk" {
+				continue
+			}
+			periods[entry.Hostname] = append(periods[entry.Hostname], Period{Start: event.Timestamp, End: eventEnd(event)})
+		}
+	}
+	for hostname, hostPeriods := range periods {
+		periods[hostname] = mergePeriods(hostPeriods)
+	}
+	return periods
+}
+
+func mergePeriods(periods []Period) []Period {
+	slices.SortFunc(periods, func(a, b Period) int {
+		return a.Start.Compare(b.Start)
+	})
+	var merged []Period
+	for _, period := range periods {
+		last := len(merged) - 1
+		if last >= 0 && !period.Start.After(merged[last].End) {
+			if period.End.After(merged[last].End) {
+				merged[last].End = period.End
+			}
+			continue
+		}
+		merged = append(merged, period)
+	}
+	return merged
+}
+
+func clipToActive(event Event, afk []Period) (Event, bool) {
+	start := event.Timestamp
+	end := eventEnd(event)
+	if !start.Before(end) {
+		for _, period := range afk {
+			if !start.Before(period.Start) && start.Before(period.End) {
+				return event, false
+			}
+		}
+		return event, true
+	}
+	var active time.Duration
+	var activeStart time.Time
+	cursor := start
+	for _, period := range afk {
+		if !period.End.After(cursor) {
+			continue
+		}
+		if !period.Start.Before(end) {
+			break
+		}
+		if period.Start.After(cursor) {
+			if active == 0 {
+				activeStart = cursor
+			}
+			active += period.Start.Sub(cursor)
+		}
+		cursor = period.End
+		if !cursor.Before(end) {
+			break
+		}
+	}
+	if cursor.Before(end) {
+		if active == 0 {
+			activeStart = cursor
+		}
+		active += end.Sub(cursor)
+	}
+	if active == 0 {
+		return event, false
+	}
+	event.Timestamp = activeStart
+	event.Duration = active.Seconds()
+	return event, true
+}
+
+func filterAfkEvents(bucketsList Buckets, bucketEvents map[string][]Event, summary *Summary) {
+	periods := afkPeriods(bucketsList, bucketEvents)
+	for _, entry := range bucketsList {
+		if entry.Type != currentWindowType && entry.Type != webTabCurrentType {
+			continue
+		}
+		afk := periods[entry.Hostname]
+		if len(afk) == 0 {
+			continue
+		}
+		var active []Event
+		for _, event := range bucketEvents[entry.ID] {
+			clipped, ok := clipToActive(event, afk)
+			if !ok {
+				summary.AfkDropped.Add(1)
+				continue
+			}
+			if clipped.Duration != event.Duration {
+				summary.AfkClipped.Add(1)
+			}
+			active = append(active, clipped)
+		}
+		bucketEvents[entry.ID] = active
+	}
+}
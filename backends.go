@@ -0,0 +1,320 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const backendInfluxDB = "influxdb"
+const backendVictoriaMetrics = "victoriametrics"
+const backendRemoteWrite = "prometheus_remote_write"
+const backendQuestDB = "questdb"
+const backendPostgres = "postgres"
+const backendClickHouse = "clickhouse"
+const backendGraphite = "graphite"
+const backendOtlp = "otlp"
+const backendLoki = "loki"
+const backendElasticsearch = "elasticsearch"
+const backendMqtt = "mqtt"
+const backendKafka = "kafka"
+const backendSQLite = "sqlite"
+const backendWebhook = "webhook"
+const backendTimestream = "timestream"
+const backendRedis = "redis"
+
+var precisions = map[string]time.Duration{"s": time.Second, "ms": time.Millisecond, "us": time.Microsecond, "ns": time.Nanosecond}
+
+var backendNames = []string{backendInfluxDB, backendVictoriaMetrics, backendRemoteWrite, backendQuestDB, backendPostgres, backendClickHouse, backendGraphite, backendOtlp, backendLoki, backendElasticsearch, backendMqtt, backendKafka, backendSQLite, backendWebhook, backendTimestream, backendRedis}
+
+const kafkaSASLPlain = "plain"
+const kafkaSASLScramSHA256 = "scram-sha-256"
+const kafkaSASLScramSHA512 = "scram-sha-512"
+
+func validateBackend(config *Config) {
+	if config.Backend == "" {
+		config.Backend = backendInfluxDB
+	}
+	if !slices.Contains(backendNames, config.Backend) {
+		log.Fatalf("Invalid Backend %q, must be one of %s\n", config.Backend, strings.Join(backendNames, ", "))
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 5000
+	}
+	if config.BatchSize < 0 {
+		log.Fatalf("Invalid BatchSize %d, must be a positive number\n", config.BatchSize)
+	}
+	if config.Precision == "" {
+		config.Precision = "s"
+	}
+	precision, ok := precisions[config.Precision]
+	if !ok {
+		log.Fatalf("Invalid Precision %q, must be s, ms, us or ns\n", config.Precision)
+	}
+	config.precision = precision
+	config.compressionLevel = gzip.DefaultCompression
+	if config.CompressionLevel != nil {
+		if *config.CompressionLevel < gzip.NoCompression || *config.CompressionLevel > gzip.BestCompression {
+			log.Fatalf("Invalid CompressionLevel %d, must be from 0 to 9\n", *config.CompressionLevel)
+		}
+		config.compressionLevel = *config.CompressionLevel
+	}
+	if config.Format != "" {
+		if !slices.Contains(formatNames, config.Format) {
+			log.Fatalf("Invalid Format %q, must be one of %s\n", config.Format, strings.Join(formatNames, ", "))
+		}
+		return
+	}
+	switch config.Backend {
+	case backendInfluxDB:
+		if config.SocketPath != "" {
+			if config.SocketType == "" {
+				config.SocketType = "unix"
+			}
+			if config.SocketType != "unix" && config.SocketType != "unixgram" {
+				log.Fatalf("Invalid SocketType %q, must be \"unix\" or \"unixgram\"\n", config.SocketType)
+			}
+			return
+		}
+		validateInfluxDB(config)
+	case backendVictoriaMetrics:
+		if config.VictoriaMetricsUrl == "" {
+			log.Fatalln("VictoriaMetricsUrl is required")
+		}
+		config.VictoriaMetricsUrl = strings.TrimRight(config.VictoriaMetricsUrl, "/")
+	case backendRemoteWrite:
+		if config.RemoteWriteUrl == "" {
+			log.Fatalln("RemoteWriteUrl is required")
+		}
+	case backendQuestDB:
+		if config.QuestDBAddress == "" {
+			log.Fatalln("QuestDBAddress is required")
+		}
+		if (config.QuestDBKeyID == "") != (config.QuestDBPrivateKey == "") {
+			log.Fatalln("QuestDBKeyID and QuestDBPrivateKey must be set together")
+		}
+	case backendPostgres:
+		if config.PostgresDSN == "" {
+			log.Fatalln("PostgresDSN is required")
+		}
+		if config.PostgresTable == "" {
+			config.PostgresTable = "activitywatch_events"
+		}
+	case backendClickHouse:
+		if config.ClickHouseUrl == "" {
+			log.Fatalln("ClickHouseUrl is required")
+		}
+		config.ClickHouseUrl = strings.TrimRight(config.ClickHouseUrl, "/")
+		if config.ClickHouseTable == "" {
+			config.ClickHouseTable = "activitywatch_events"
+		}
+	case backendGraphite:
+		if config.GraphiteAddress == "" {
+			log.Fatalln("GraphiteAddress is required")
+		}
+		if config.GraphitePrefix == "" {
+			config.GraphitePrefix = "aw"
+		}
+		if config.GraphiteFormat == "" {
+			config.GraphiteFormat = graphiteFormatPath
+		}
+		if config.GraphiteFormat != graphiteFormatPath && config.GraphiteFormat != graphiteFormatTagged {
+			log.Fatalf("Invalid GraphiteFormat %q, must be %q or %q\n", config.GraphiteFormat, graphiteFormatPath, graphiteFormatTagged)
+		}
+	case backendOtlp:
+		if config.OtlpEndpoint == "" {
+			log.Fatalln("OtlpEndpoint is required")
+		}
+		endpoint, err := url.Parse(config.OtlpEndpoint)
+		if err != nil {
+			log.Fatalf("Invalid OtlpEndpoint %q: %s\n", config.OtlpEndpoint, err)
+		}
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			config.OtlpEndpoint = strings.TrimRight(config.OtlpEndpoint, "/") + "/v1/metrics"
+		}
+	case backendLoki:
+		if config.LokiUrl == "" {
+			log.Fatalln("LokiUrl is required")
+		}
+		config.LokiUrl = strings.TrimSuffix(strings.TrimRight(config.LokiUrl, "/"), lokiPushPath)
+	case backendElasticsearch:
+		if config.ElasticsearchUrl == "" {
+			log.Fatalln("ElasticsearchUrl is required")
+		}
+		config.ElasticsearchUrl = strings.TrimRight(config.ElasticsearchUrl, "/")
+		if config.ElasticsearchIndex == "" {
+			config.ElasticsearchIndex = "activitywatch-%{+yyyy.MM.dd}"
+		}
+	case backendMqtt:
+		if config.MqttBroker == "" {
+			log.Fatalln("MqttBroker is required")
+		}
+		if config.MqttTopic == "" {
+			config.MqttTopic = "activitywatch/{hostname}/{type}"
+		}
+		if config.MqttClientID == "" {
+			hostname, _ := os.Hostname()
+			config.MqttClientID = "activitywatch_exporter-" + hostname
+		}
+		if config.MqttQoS < 0 || config.MqttQoS > 2 {
+			log.Fatalf("Invalid MqttQoS %d, must be 0, 1 or 2\n", config.MqttQoS)
+		}
+	case backendTimestream:
+		if !timestreamEnabled {
+			log.Fatalln("The timestream backend requires a build with the timestream tag: go build -tags timestream")
+		}
+		if config.TimestreamDatabase == "" || config.TimestreamTable == "" {
+			log.Fatalln("TimestreamDatabase and TimestreamTable are required")
+		}
+	case backendRedis:
+		if config.RedisAddress == "" {
+			log.Fatalln("RedisAddress is required")
+		}
+		if config.RedisCAFile != "" || config.RedisInsecureSkipVerify {
+			config.RedisTLS = true
+		}
+		if config.RedisRetention != "" {
+			retention, err := time.ParseDuration(config.RedisRetention)
+			if err != nil || retention <= 0 {
+				log.Fatalf("Invalid RedisRetention %q, must be a positive duration like 720h\n", config.RedisRetention)
+			}
+			config.redisRetention = retention
+		}
+	case backendWebhook:
+		if config.WebhookUrl == "" {
+			log.Fatalln("WebhookUrl is required")
+		}
+		if config.WebhookMaxBytes == 0 {
+			config.WebhookMaxBytes = 1 << 20
+		}
+		if config.WebhookMaxBytes < 0 {
+			log.Fatalf("Invalid WebhookMaxBytes %d, must be a positive number\n", config.WebhookMaxBytes)
+		}
+	case backendSQLite:
+		if config.Output == "" {
+			log.Fatalln("The sqlite backend requires the path of the database in Output or -output")
+		}
+	case backendKafka:
+		if !kafkaEnabled {
+			log.Fatalln("The kafka backend requires a build with the kafka tag: go build -tags kafka")
+		}
+		if len(config.KafkaBrokers) == 0 || config.KafkaTopic == "" {
+			log.Fatalln("KafkaBrokers and KafkaTopic are required")
+		}
+		if config.KafkaSASLMechanism != "" && !slices.Contains([]string{kafkaSASLPlain, kafkaSASLScramSHA256, kafkaSASLScramSHA512}, config.KafkaSASLMechanism) {
+			log.Fatalf("Invalid KafkaSASLMechanism %q, must be %q, %q or %q\n", config.KafkaSASLMechanism, kafkaSASLPlain, kafkaSASLScramSHA256, kafkaSASLScramSHA512)
+		}
+	}
+}
+
+func clientTLSConfig(option string, caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", option, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s %s", option, caFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func initSchema(ctx context.Context, client *http.Client, config Config) error {
+	switch config.Backend {
+	case backendPostgres:
+		return initPostgresSchema(ctx, config)
+	case backendClickHouse:
+		return initClickHouseSchema(ctx, client, config)
+	case backendElasticsearch:
+		return initElasticsearchTemplate(ctx, client, config)
+	case backendSQLite:
+		return initSQLiteSchema(ctx, config)
+	default:
+		return fmt.Errorf("the %s backend has no schema to create", config.Backend)
+	}
+}
+
+// writePrecision returns the precision the timestamps are stored with, the line protocol
+// backends use Precision while QuestDB and the other backends have a fixed one
+func writePrecision(config Config) time.Duration {
+	if config.Format != "" {
+		if config.Format == formatLineProtocol {
+			return config.precision
+		}
+		return time.Nanosecond
+	}
+	switch config.Backend {
+	case backendInfluxDB, backendVictoriaMetrics:
+		return config.precision
+	case backendGraphite:
+		return time.Second
+	case backendRemoteWrite, backendTimestream, backendRedis:
+		return time.Millisecond
+	case backendPostgres:
+		return time.Microsecond
+	default:
+		return time.Nanosecond
+	}
+}
+
+func writePoints(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	if config.Format != "" {
+		return writeOutput(config, points)
+	}
+	switch config.Backend {
+	case backendVictoriaMetrics:
+		payload := linesPayload(points, config.precision)
+		return len(payload), writeVictoriaMetrics(ctx, client, config, payload)
+	case backendRemoteWrite:
+		return writeRemoteWrite(ctx, client, config, points)
+	case backendQuestDB:
+		payload := linesPayload(points, writePrecision(config))
+		return len(payload), writeQuestDB(ctx, config, payload)
+	case backendPostgres:
+		return 0, writePostgres(ctx, config, points)
+	case backendClickHouse:
+		return writeClickHouse(ctx, client, config, points)
+	case backendGraphite:
+		return writeGraphite(ctx, config, points)
+	case backendOtlp:
+		return writeOtlp(ctx, client, config, points)
+	case backendLoki:
+		return writeLoki(ctx, client, config, points)
+	case backendElasticsearch:
+		return writeElasticsearch(ctx, client, config, points)
+	case backendMqtt:
+		return writeMqtt(ctx, config, points)
+	case backendKafka:
+		return writeKafka(ctx, config, points)
+	case backendSQLite:
+		return 0, writeSQLite(ctx, config, points)
+	case backendWebhook:
+		return writeWebhook(ctx, client, config, points)
+	case backendTimestream:
+		return 0, writeTimestream(ctx, config, points)
+	case backendRedis:
+		return 0, writeRedis(ctx, config, points)
+	default:
+		if config.SocketPath != "" {
+			payload := linesPayload(points, config.precision)
+			return len(payload), writeSocket(ctx, config, payload)
+		}
+		if strings.HasPrefix(config.WriteURL, udpScheme+"://") {
+			payload := linesPayload(points, config.precision)
+			return len(payload), writeUDP(ctx, config, payload)
+		}
+		return writeInfluxDBPoints(ctx, client, config, points)
+	}
+}
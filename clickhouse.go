@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const clickHouseSchema = `CREATE TABLE IF NOT EXISTS %s (
+	time DateTime64(3, 'UTC'),
+	measurement LowCardinality(String),
+	series String,
+	bucket String,
+	event_id UInt64,
+	tags Map(String, String),
+	fields Map(String, Float64),
+	text_fields Map(String, String)
+) ENGINE = ReplacingMergeTree
+ORDER BY (measurement, series, time, bucket, event_id)`
+
+type ClickHouseRow struct {
+	Time        string             `json:"time"`
+	Measurement string             `json:"measurement"`
+	Series      string             `json:"series"`
+	Bucket      string             `json:"bucket"`
+	EventID     int                `json:"event_id"`
+	Tags        map[string]string  `json:"tags"`
+	Fields      map[string]float64 `json:"fields"`
+	TextFields  map[string]string  `json:"text_fields"`
+}
+
+func clickHouseRow(point Point) ClickHouseRow {
+	row := ClickHouseRow{
+		Time:        point.Time.UTC().Format("2006-01-02 15:04:05.000"),
+		Measurement: point.Measurement,
+		Bucket:      point.BucketID,
+		EventID:     point.EventID,
+		Tags:        pointTagsMap(point),
+		Fields:      make(map[string]float64),
+		TextFields:  make(map[string]string),
+	}
+	var series strings.Builder
+	for _, tag := range point.Tags {
+		fmt.Fprintf(&series, ",%s=%s", tag.Key, escapeTagValue(tag.Value))
+	}
+	row.Series = strings.TrimPrefix(series.String(), ",")
+	for _, field := range point.Fields {
+		if value, ok := sampleValue(field.Value); ok {
+			row.Fields[field.Key] = value
+		} else {
+			row.TextFields[field.Key] = fmt.Sprint(field.Value)
+		}
+	}
+	return row
+}
+
+func clickHouseQuery(ctx context.Context, client *http.Client, config Config, query string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	queryUrl := config.ClickHouseUrl + "/?" + url.Values{"query": {query}}.Encode()
+	req, _ := http.NewRequestWithContext(ctx, "POST", queryUrl, bytes.NewReader(body))
+	if config.ClickHouseUser != "" {
+		req.Header.Set("X-ClickHouse-User", config.ClickHouseUser)
+		req.Header.Set("X-ClickHouse-Key", config.ClickHousePassword)
+	}
+	if config.ClickHouseToken != "" {
+		req.Header.Set("Authorization", "Bearer "+config.ClickHouseToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+func initClickHouseSchema(ctx context.Context, client *http.Client, config Config) error {
+	return clickHouseQuery(ctx, client, config, fmt.Sprintf(clickHouseSchema, config.ClickHouseTable), nil)
+}
+
+func writeClickHouse(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	var written int
+	insert := fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", config.ClickHouseTable)
+	for start := 0; start < len(points); start += config.BatchSize {
+		var body bytes.Buffer
+		encoder := json.NewEncoder(&body)
+		for _, point := range points[start:min(start+config.BatchSize, len(points))] {
+			err := encoder.Encode(clickHouseRow(point))
+			if err != nil {
+				return written, err
+			}
+		}
+		err := clickHouseQuery(ctx, client, config, insert, body.Bytes())
+		if err != nil {
+			return written, fmt.Errorf("error sending data: %w", err)
+		}
+		written += body.Len()
+	}
+	debugf("Inserted %d rows into ClickHouse\n", len(points))
+	return written, nil
+}
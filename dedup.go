@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"slices"
+	"time"
+)
+
+const dedupLines = "lines"
+const dedupSeries = "series"
+
+// dedupPoints drops the points whose line, or series and timestamp with the
+// series mode, is repeated later in points, keeping the last one like InfluxDB.
+// The precision is the one the backend stores the timestamps with
+func dedupPoints(points []Point, mode string, precision time.Duration) ([]Point, int) {
+	seen := make(map[uint64]struct{}, len(points))
+	kept := make([]Point, 0, len(points))
+	// walking backwards keeps the last point of every hash with a single hash per point
+	for i := len(points) - 1; i >= 0; i-- {
+		point := points[i]
+		h := fnv.New64a()
+		if mode == dedupSeries {
+			io.WriteString(h, escapeMeasurement(point.Measurement))
+			for _, tag := range point.Tags {
+				fmt.Fprintf(h, ",%s=%s", tag.Key, escapeTagValue(tag.Value))
+			}
+			fmt.Fprintf(h, " %d", point.Time.UnixNano()/int64(precision))
+		} else {
+			io.WriteString(h, point.LineProtocol(precision))
+		}
+		key := h.Sum64()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		kept = append(kept, point)
+	}
+	slices.Reverse(kept)
+	return kept, len(points) - len(kept)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDedupPoints(t *testing.T) {
+	point := func(offset time.Duration, app string, duration float64) Point {
+		point := Point{Measurement: currentWindowType, Time: testTime.Add(offset)}
+		point.AddTag("app", app)
+		point.AddField("duration", duration)
+		return point
+	}
+	points := []Point{
+		point(0, "Code", 30),
+		point(0, "Code", 30),
+		point(0, "Code", 12),
+		point(0, "Code,Firefox", 30),
+		point(0, "Code", 30),
+		point(time.Millisecond, "Code", 30),
+		point(time.Second, "Code", 30),
+	}
+	tests := []struct {
+		name      string
+		mode      string
+		precision time.Duration
+		want      []int
+	}{
+		{"lines", dedupLines, time.Second, []int{2, 3, 5, 6}},
+		{"lines in nanoseconds", dedupLines, time.Nanosecond, []int{2, 3, 4, 5, 6}},
+		{"series", dedupSeries, time.Second, []int{3, 5, 6}},
+		{"series in milliseconds", dedupSeries, time.Millisecond, []int{3, 4, 5, 6}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var want []Point
+			for _, i := range test.want {
+				want = append(want, points[i])
+			}
+			got, dropped := dedupPoints(slices.Clone(points), test.mode, test.precision)
+			if dropped != len(points)-len(want) {
+				t.Errorf("dedupPoints() dropped %d points, want %d", dropped, len(points)-len(want))
+			}
+			if string(linesPayload(got, time.Nanosecond)) != string(linesPayload(want, time.Nanosecond)) {
+				t.Errorf("dedupPoints() =\n%s\nwant\n%s", linesPayload(got, time.Nanosecond), linesPayload(want, time.Nanosecond))
+			}
+		})
+	}
+}
+
+func TestWritePrecision(t *testing.T) {
+	tests := []struct {
+		backend string
+		format  string
+		want    time.Duration
+	}{
+		{backendInfluxDB, "", time.Second},
+		{backendVictoriaMetrics, "", time.Second},
+		{backendQuestDB, "", time.Nanosecond},
+		{backendGraphite, "", time.Second},
+		{backendRemoteWrite, "", time.Millisecond},
+		{backendPostgres, "", time.Microsecond},
+		{backendQuestDB, formatLineProtocol, time.Second},
+		{backendInfluxDB, formatJSONL, time.Nanosecond},
+	}
+	for _, test := range tests {
+		t.Run(test.backend+test.format, func(t *testing.T) {
+			config := Config{Backend: test.backend, Format: test.format, precision: time.Second}
+			if got := writePrecision(config); got != test.want {
+				t.Errorf("writePrecision() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
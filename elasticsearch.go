@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var elasticsearchDatePattern = regexp.MustCompile(`%\{\+([^}]+)\}`)
+var elasticsearchDateLayout = strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15")
+
+const elasticsearchTemplate = `{
+	"index_patterns": [%q],
+	"template": {
+		"mappings": {
+			"dynamic_templates": [{"strings": {"match_mapping_type": "string", "mapping": {"type": "keyword"}}}],
+			"properties": {
+				"@timestamp": {"type": "date_nanos"},
+				"type": {"type": "keyword"},
+				"hostname": {"type": "keyword"},
+				"client": {"type": "keyword"}
+			}
+		}
+	}
+}`
+
+type ElasticsearchBulkItem struct {
+	Status int `json:"status"`
+	Error  struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+type ElasticsearchBulkResponse struct {
+	Errors bool                               `json:"errors"`
+	Items  []map[string]ElasticsearchBulkItem `json:"items"`
+}
+
+func elasticsearchIndex(pattern string, t time.Time) string {
+	return elasticsearchDatePattern.ReplaceAllStringFunc(pattern, func(match string) string {
+		layout := elasticsearchDatePattern.FindStringSubmatch(match)[1]
+		return t.UTC().Format(elasticsearchDateLayout.Replace(layout))
+	})
+}
+
+func elasticsearchDocumentID(point Point) string {
+	var key strings.Builder
+	if point.EventID != 0 {
+		fmt.Fprintf(&key, "%s\xff%d", point.BucketID, point.EventID)
+	} else {
+		fmt.Fprintf(&key, "%s\xff%d", point.Measurement, point.Time.UnixNano())
+		for _, tag := range point.Tags {
+			fmt.Fprintf(&key, "\xff%s=%s", tag.Key, tag.Value)
+		}
+	}
+	sum := sha256.Sum256([]byte(key.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func elasticsearchDocument(point Point) map[string]any {
+	document := map[string]any{
+		"@timestamp": point.Time.UTC().Format(time.RFC3339Nano),
+		"type":       point.Measurement,
+	}
+	for _, tag := range point.Tags {
+		document[tag.Key] = tag.Value
+	}
+	for _, field := range point.Fields {
+		document[field.Key] = field.Value
+	}
+	return document
+}
+
+func elasticsearchBulkBody(config Config, points []Point) ([]byte, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, point := range points {
+		action := map[string]map[string]string{"index": {
+			"_index": elasticsearchIndex(config.ElasticsearchIndex, point.Time),
+			"_id":    elasticsearchDocumentID(point),
+		}}
+		err := encoder.Encode(action)
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Encode(elasticsearchDocument(point))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body.Bytes(), nil
+}
+
+func elasticsearchRequest(ctx context.Context, client *http.Client, config Config, method string, path string, contentType string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, method, config.ElasticsearchUrl+path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if config.ElasticsearchApiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+config.ElasticsearchApiKey)
+	} else if config.ElasticsearchUsername != "" {
+		req.SetBasicAuth(config.ElasticsearchUsername, config.ElasticsearchPassword)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending data: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return nil, fmt.Errorf("error reading data: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("error sending data: %s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	return respBody, nil
+}
+
+func initElasticsearchTemplate(ctx context.Context, client *http.Client, config Config) error {
+	pattern := elasticsearchDatePattern.ReplaceAllString(config.ElasticsearchIndex, "*")
+	_, err := elasticsearchRequest(ctx, client, config, "PUT", "/_index_template/activitywatch", "application/json", fmt.Appendf(nil, elasticsearchTemplate, pattern))
+	if err != nil {
+		return fmt.Errorf("error creating the Elasticsearch index template: %w", err)
+	}
+	return nil
+}
+
+func postElasticsearchBulk(ctx context.Context, client *http.Client, config Config, points []Point) (int, []Point, int, error) {
+	body, err := elasticsearchBulkBody(config, points)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	respBody, err := elasticsearchRequest(ctx, client, config, "POST", "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	var bulk ElasticsearchBulkResponse
+	err = json.Unmarshal(respBody, &bulk)
+	if err != nil {
+		return len(body), nil, 0, fmt.Errorf("error unmarshalling the bulk response: %w", err)
+	}
+	if !bulk.Errors {
+		return len(body), nil, 0, nil
+	}
+	var retryable []Point
+	var rejected int
+	var lastError string
+	for i, result := range bulk.Items {
+		for _, item := range result {
+			if item.Status/100 == 2 {
+				continue
+			}
+			lastError = fmt.Sprintf("%s: %s", item.Error.Type, item.Error.Reason)
+			if item.Status == http.StatusTooManyRequests || item.Status >= 500 {
+				retryable = append(retryable, points[i])
+			} else {
+				rejected++
+			}
+		}
+	}
+	log.Printf("Warning: Elasticsearch rejected %d of %d documents, last error: %s\n", rejected+len(retryable), len(points), lastError)
+	return len(body), retryable, rejected, nil
+}
+
+func writeElasticsearch(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	var written, rejected int
+	for start := 0; start < len(points); start += config.BatchSize {
+		n, retryable, batchRejected, err := postElasticsearchBulk(ctx, client, config, points[start:min(start+config.BatchSize, len(points))])
+		written += n
+		rejected += batchRejected
+		if err != nil {
+			return written, err
+		}
+		if len(retryable) == 0 {
+			continue
+		}
+		log.Printf("Retrying %d documents rejected by Elasticsearch\n", len(retryable))
+		n, retryable, batchRejected, err = postElasticsearchBulk(ctx, client, config, retryable)
+		written += n
+		rejected += batchRejected + len(retryable)
+		if err != nil {
+			return written, err
+		}
+	}
+	if rejected > 0 {
+		return written, fmt.Errorf("%d documents rejected by Elasticsearch", rejected)
+	}
+	return written, nil
+}
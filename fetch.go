@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const awTimeFormat = "2006-01-02T15:04:05.000000-07:00"
+
+var errResponseTooLarge = errors.New("response too large")
+
+var errBucketNotFound = errors.New("bucket not found")
+var errFetchAborted = errors.New("fetching aborted after a failed bucket")
+
+func readBody(body io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w, over %d MB", errResponseTooLarge, limit>>20)
+	}
+	return data, nil
+}
+
+func activityWatchApiUrl(config Config, elem ...string) string {
+	apiUrl, _ := url.JoinPath(config.ActivityWatchUrl, elem...)
+	return apiUrl
+}
+
+// parseActivityWatchUrl parses the ActivityWatchUrl option without its trailing slashes,
+// the error explains what the URL must look like
+func parseActivityWatchUrl(value string) (*url.URL, error) {
+	awUrl, err := url.Parse(strings.TrimRight(value, "/"))
+	if err != nil || (awUrl.Scheme != "http" && awUrl.Scheme != "https" && awUrl.Scheme != sshScheme) || awUrl.Host == "" {
+		return nil, errors.New("must be an http, https or ssh URL like http://localhost:5600 or ssh://user@desktop")
+	}
+	if awUrl.RawQuery != "" || awUrl.Fragment != "" {
+		return nil, errors.New("must not have a query or fragment")
+	}
+	if _, password := awUrl.User.Password(); password {
+		return nil, errors.New("SSH passwords are not supported, use SSHKeyFile or an ssh-agent")
+	}
+	return awUrl, nil
+}
+
+func chunkPeriods(start time.Time, end time.Time, size time.Duration) []Period {
+	var periods []Period
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(size) {
+		chunkEnd := chunkStart.Add(size)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		periods = append(periods, Period{Start: chunkStart, End: chunkEnd})
+	}
+	return periods
+}
+
+func fetchEventsPage(ctx context.Context, client *http.Client, config Config, bucketID string, start time.Time, end time.Time) ([]Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.eventsTimeout)
+	defer cancel()
+	query := url.Values{
+		"start": {start.Format(awTimeFormat)},
+		"end":   {end.Format(awTimeFormat)},
+		"limit": {strconv.Itoa(config.PageSize)},
+	}
+	eventsUrl := activityWatchApiUrl(config, bucketsApiPath, url.PathEscape(bucketID), "events") + "?" + query.Encode()
+	eventsReq, _ := http.NewRequestWithContext(ctx, "GET", eventsUrl, nil)
+	eventsResp, err := client.Do(eventsReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending the events request: %w", err)
+	}
+	defer eventsResp.Body.Close()
+	eventsBody, err := readBody(eventsResp.Body, config.maxResponseSize)
+	if errors.Is(err, errResponseTooLarge) {
+		return nil, fmt.Errorf("%w, consider reducing ChunkSize, PageSize or -days", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading events data: %w", err)
+	}
+	if eventsResp.StatusCode == http.StatusNotFound {
+		return nil, errBucketNotFound
+	}
+	if eventsResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error response to the events request: %s: %s", eventsResp.Status, apiErrorMessage(eventsBody))
+	}
+	var events []Event
+	err = json.Unmarshal(eventsBody, &events)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling events data %s: %w", apiErrorMessage(eventsBody), err)
+	}
+	return events, nil
+}
+
+func fetchEventsChunk(ctx context.Context, client *http.Client, config Config, bucketID string, chunk Period) ([]Event, error) {
+	var chunkEvents []Event
+	seen := make(map[int]bool)
+	end := chunk.End
+	for {
+		events, err := fetchEventsPage(ctx, client, config, bucketID, chunk.Start, end)
+		if err != nil {
+			return nil, err
+		}
+		oldest := end
+		for _, event := range events {
+			if event.Timestamp.Before(oldest) {
+				oldest = event.Timestamp
+			}
+			if seen[event.ID] || event.Timestamp.Before(chunk.Start) || !event.Timestamp.Before(chunk.End) {
+				continue
+			}
+			seen[event.ID] = true
+			chunkEvents = append(chunkEvents, event)
+		}
+		if len(events) < config.PageSize {
+			return chunkEvents, nil
+		}
+		if !oldest.Before(end) {
+			log.Printf("Warning: more than %d events of bucket=%s share the timestamp %s, increase PageSize to fetch all of them\n", config.PageSize, bucketID, end.Format(time.RFC3339))
+			return chunkEvents, nil
+		}
+		debugf("Fetched page of %d events of bucket=%s, fetching next page ending at %s\n", len(events), bucketID, oldest.Format(time.RFC3339))
+		end = oldest
+	}
+}
+
+// fetchEventChunks fetches the events of the bucket in chunks of ChunkSize, passing every chunk to handle
+func fetchEventChunks(ctx context.Context, client *http.Client, config Config, bucketID string, start time.Time, end time.Time, handle func([]Event)) error {
+	chunks := chunkPeriods(start, end, config.chunkSize)
+	for i, chunk := range chunks {
+		chunkEvents, err := fetchEventsChunk(ctx, client, config, bucketID, chunk)
+		if err != nil {
+			return err
+		}
+		if len(chunks) > 1 {
+			log.Printf("Fetched %d events of bucket=%s from chunk %d of %d (%s)\n", len(chunkEvents), bucketID, i+1, len(chunks), chunk.Start.Format(time.DateOnly))
+		}
+		handle(chunkEvents)
+	}
+	return nil
+}
+
+// fetchBuckets fetches the events of the buckets with a pool of config.concurrency
+// workers, passing the events of every bucket to handle from the worker that fetched them.
+// With StreamBatches the events are passed as soon as every chunk is fetched instead,
+// and the chunks fetched before a failure are kept
+func fetchBuckets(ctx context.Context, client *http.Client, config Config, entries []Bucket, afkBuckets map[string][]string, period Period, summary *Summary, apiErrors *atomic.Int64, handle func(Bucket, []Event)) error {
+	fetchCtx, cancelFetch := context.WithCancelCause(ctx)
+	defer cancelFetch(nil)
+	fetchFailed := func(message string, err error) {
+		if errors.Is(context.Cause(fetchCtx), errFetchAborted) {
+			return
+		}
+		handleApiError(message, err, apiErrors)
+		if config.failFast {
+			cancelFetch(errFetchAborted)
+		}
+	}
+	wg := &sync.WaitGroup{}
+	buckets := make(chan Bucket)
+	for range config.concurrency {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for entry := range buckets {
+				var events []Event
+				hostAfkBuckets := afkBuckets[entry.Hostname]
+				if config.QueryNonAfkWindows && entry.Type == currentWindowType && len(hostAfkBuckets) > 0 {
+					queryEvents, err := queryNonAfkEvents(fetchCtx, client, config, entry.ID, hostAfkBuckets, period.Start, period.End)
+					if err != nil {
+						fetchFailed(fmt.Sprintf("Error querying non-afk events for bucket=%s:", entry.ID), err)
+						continue
+					}
+					events = queryEvents
+				} else {
+					var fetchedEvents []Event
+					err := fetchEventChunks(fetchCtx, client, config, entry.ID, period.Start, period.End, func(chunkEvents []Event) {
+						if config.StreamBatches {
+							handle(entry, chunkEvents)
+							return
+						}
+						fetchedEvents = append(fetchedEvents, chunkEvents...)
+					})
+					if errors.Is(err, errBucketNotFound) {
+						log.Printf("Warning: bucket=%s no longer exists, skipping\n", entry.ID)
+						summary.MissingBuckets.Add(1)
+						continue
+					}
+					if err != nil {
+						fetchFailed(fmt.Sprintf("Error trying to get events for bucket=%s:", entry.ID), err)
+						continue
+					}
+					if config.StreamBatches {
+						continue
+					}
+					events = fetchedEvents
+				}
+				handle(entry, events)
+			}
+		}()
+	}
+
+	for _, entry := range entries {
+		select {
+		case buckets <- entry:
+		case <-fetchCtx.Done():
+		}
+	}
+	close(buckets)
+	wg.Wait()
+	if errors.Is(context.Cause(fetchCtx), errFetchAborted) {
+		return errFetchAborted
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+func activityPeriods(bucketsList Buckets, bucketEvents map[string][]Event) map[string][]Period {
+	periods := make(map[string][]Period)
+	for _, entry := range bucketsList {
+		if entry.Type != currentWindowType && entry.Type != afkType {
+			continue
+		}
+		for _, event := range bucketEvents[entry.ID] {
+			periods[entry.Hostname] = append(periods[entry.Hostname], Period{Start: event.Timestamp, End: eventEnd(event)})
+		}
+	}
+	for hostname, hostPeriods := range periods {
+		periods[hostname] = mergePeriods(hostPeriods)
+	}
+	return periods
+}
+
+func gapPoints(config Config, bucketsList Buckets, bucketEvents map[string][]Event) []Point {
+	var points []Point
+	periods := activityPeriods(bucketsList, bucketEvents)
+	for _, hostname := range slices.Sorted(maps.Keys(periods)) {
+		hostPeriods := periods[hostname]
+		for i := 1; i < len(hostPeriods); i++ {
+			gap := hostPeriods[i].Start.Sub(hostPeriods[i-1].End)
+			if gap <= config.gapThreshold {
+				continue
+			}
+			debugf("Gap of %s without data for hostname=%s from %s\n", gap, hostname, hostPeriods[i-1].End.Format(time.RFC3339))
+			point := Point{Measurement: "aw_gap", Time: hostPeriods[i-1].End}
+			point.AddTags(hostTags(config, hostname)...)
+			point.Fields = durationFields(gap.Seconds(), config.DurationUnit)
+			points = append(points, point)
+		}
+	}
+	return points
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type GrafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd"`
+	Tags    []string `json:"tags"`
+	Text    string   `json:"text"`
+}
+
+func (annotation GrafanaAnnotation) key() string {
+	return fmt.Sprintf("%d\xff%d\xff%s", annotation.Time, annotation.TimeEnd, annotation.Text)
+}
+
+func stopwatchAnnotations(config Config, points []Point) []GrafanaAnnotation {
+	var annotations []GrafanaAnnotation
+	for _, point := range points {
+		if point.Measurement != stopwatchType {
+			continue
+		}
+		seconds, _ := pointDuration(point)
+		duration := time.Duration(seconds * float64(time.Second))
+		start, end := point.Time, point.Time.Add(duration)
+		if config.TimestampAt == timestampAtEnd {
+			start, end = point.Time.Add(-duration), point.Time
+		}
+		text := point.Tag("label")
+		if text == "" {
+			text = "stopwatch"
+		}
+		annotations = append(annotations, GrafanaAnnotation{
+			Time:    start.UnixMilli(),
+			TimeEnd: end.UnixMilli(),
+			Tags:    config.GrafanaAnnotationTags,
+			Text:    text,
+		})
+	}
+	return annotations
+}
+
+func grafanaRequest(ctx context.Context, client *http.Client, config Config, method string, path string, body []byte, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, method, config.GrafanaUrl+path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.GrafanaToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func existingAnnotations(ctx context.Context, client *http.Client, config Config, annotations []GrafanaAnnotation) (map[string]bool, error) {
+	from, to := annotations[0].Time, annotations[0].TimeEnd
+	for _, annotation := range annotations {
+		from = min(from, annotation.Time)
+		to = max(to, annotation.TimeEnd)
+	}
+	query := url.Values{
+		"type":  {"annotation"},
+		"from":  {strconv.FormatInt(from, 10)},
+		"to":    {strconv.FormatInt(to, 10)},
+		"tags":  config.GrafanaAnnotationTags,
+		"limit": {"10000"},
+	}
+	var existing []GrafanaAnnotation
+	err := grafanaRequest(ctx, client, config, "GET", "/api/annotations?"+query.Encode(), nil, &existing)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, annotation := range existing {
+		keys[annotation.key()] = true
+	}
+	return keys, nil
+}
+
+func createGrafanaAnnotations(ctx context.Context, client *http.Client, config Config, points []Point) {
+	annotations := stopwatchAnnotations(config, points)
+	if len(annotations) == 0 {
+		return
+	}
+	existing, err := existingAnnotations(ctx, client, config, annotations)
+	if err != nil {
+		log.Println("Warning: unable to fetch the existing Grafana annotations:", err)
+		return
+	}
+	var created int
+	for _, annotation := range annotations {
+		if existing[annotation.key()] {
+			continue
+		}
+		body, _ := json.Marshal(annotation)
+		err = grafanaRequest(ctx, client, config, "POST", "/api/annotations", body, nil)
+		if err != nil {
+			log.Println("Warning: unable to create the Grafana annotation:", err)
+			continue
+		}
+		existing[annotation.key()] = true
+		created++
+	}
+	debugf("Created %d Grafana annotations, %d already existed\n", created, len(annotations)-created)
+}
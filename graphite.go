@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const graphiteFormatPath = "path"
+const graphiteFormatTagged = "tagged"
+const graphiteChunkLines = 1000
+
+var invalidGraphitePathChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+var invalidGraphiteTagChars = regexp.MustCompile(`[;~!^=\s]`)
+
+var graphitePathSkippedTags = []string{"hostname", "client", "device", "bucket"}
+
+func graphitePathComponent(value string) string {
+	return invalidGraphitePathChars.ReplaceAllString(value, "_")
+}
+
+func graphiteLines(config Config, point Point) []string {
+	var lines []string
+	for _, field := range point.Fields {
+		value, ok := sampleValue(field.Value)
+		if !ok {
+			continue
+		}
+		var metric string
+		if config.GraphiteFormat == graphiteFormatTagged {
+			metric = strings.Join([]string{config.GraphitePrefix, graphitePathComponent(point.Measurement), graphitePathComponent(field.Key)}, ".")
+			for _, tag := range slices.Concat(point.Tags, labelTags(point)) {
+				metric += fmt.Sprintf(";%s=%s", graphitePathComponent(tag.Key), invalidGraphiteTagChars.ReplaceAllString(tag.Value, "_"))
+			}
+		} else {
+			path := []string{config.GraphitePrefix, graphitePathComponent(point.Tag("hostname")), graphitePathComponent(point.Measurement)}
+			for _, tag := range slices.Concat(point.Tags, labelTags(point)) {
+				if !slices.Contains(graphitePathSkippedTags, tag.Key) {
+					path = append(path, graphitePathComponent(tag.Value))
+				}
+			}
+			metric = strings.Join(append(path, graphitePathComponent(field.Key)), ".")
+		}
+		lines = append(lines, fmt.Sprintf("%s %g %d\n", metric, value, point.Time.Unix()))
+	}
+	return lines
+}
+
+func dialGraphite(ctx context.Context, config Config) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", config.GraphiteAddress)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to Graphite: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	return conn, nil
+}
+
+func writeGraphite(ctx context.Context, config Config, points []Point) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	var lines []string
+	for _, point := range points {
+		lines = append(lines, graphiteLines(config, point)...)
+	}
+	conn, err := dialGraphite(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		conn.Close()
+	}()
+	var written int
+	for start := 0; start < len(lines); start += graphiteChunkLines {
+		chunk := []byte(strings.Join(lines[start:min(start+graphiteChunkLines, len(lines))], ""))
+		for retries := 0; ; retries++ {
+			_, err = bytes.NewReader(chunk).WriteTo(conn)
+			if err == nil {
+				break
+			}
+			if retries >= retryCount {
+				return written, fmt.Errorf("error sending data: %w", err)
+			}
+			log.Println("Warning: error sending data to Graphite, reconnecting:", err)
+			conn.Close()
+			conn, err = dialGraphite(ctx, config)
+			if err != nil {
+				return written, err
+			}
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGraphiteLines(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{graphiteFormatPath, []string{
+			"aw.laptop_example_org.afkstatus.not-afk.duration 30 1741944413\n",
+			"aw.laptop_example_org.web_tab_current.github_com_8080.duration 12.5 1741944413\n",
+			"aw.laptop_example_org.web_tab_current.github_com_8080.audible 1 1741944413\n",
+		}},
+		{graphiteFormatTagged, []string{
+			"aw.afkstatus.duration;hostname=laptop.example.org;status=not-afk 30 1741944413\n",
+			"aw.web_tab_current.duration;hostname=laptop.example.org;url=github.com:8080 12.5 1741944413\n",
+			"aw.web_tab_current.audible;hostname=laptop.example.org;url=github.com:8080 1 1741944413\n",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			config := Config{GraphitePrefix: "aw", GraphiteFormat: test.format}
+			var lines []string
+			for _, point := range testLabelPoints() {
+				lines = append(lines, graphiteLines(config, point)...)
+			}
+			if !slices.Equal(lines, test.want) {
+				t.Errorf("graphiteLines() = %q, want %q", lines, test.want)
+			}
+		})
+	}
+}
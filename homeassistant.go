@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var invalidEntityChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+type HomeAssistantState struct {
+	State      string         `json:"state"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+type HostActivity struct {
+	App           *Point
+	Afk           *Point
+	ActiveSeconds float64
+}
+
+func entityID(hostname string, sensor string) string {
+	host := strings.Trim(invalidEntityChars.ReplaceAllString(strings.ToLower(hostname), "_"), "_")
+	return fmt.Sprintf("sensor.activitywatch_%s_%s", host, sensor)
+}
+
+func hostActivities(config Config, points []Point, now time.Time) map[string]*HostActivity {
+	activities := make(map[string]*HostActivity)
+	for i, point := range points {
+		hostname := point.Tag("hostname")
+		if point.Measurement != currentWindowType && point.Measurement != afkType {
+			continue
+		}
+		activity, ok := activities[hostname]
+		if !ok {
+			activity = &HostActivity{}
+			activities[hostname] = activity
+		}
+		if point.Measurement == currentWindowType {
+			if activity.App == nil || point.Time.After(activity.App.Time) {
+				activity.App = &points[i]
+			}
+			continue
+		}
+		if activity.Afk == nil || point.Time.After(activity.Afk.Time) {
+			activity.Afk = &points[i]
+		}
+		location := hostnameLocation(config, hostname)
+		status := optionalField[string](point, "status")
+		if status != nil && *status == "not-afk" && startOfDay(point.Time, location).Equal(startOfDay(now, location)) {
+			duration, _ := pointDuration(point)
+			activity.ActiveSeconds += duration
+		}
+	}
+	return activities
+}
+
+func homeAssistantStates(config Config, points []Point, now time.Time) map[string]HomeAssistantState {
+	states := make(map[string]HomeAssistantState)
+	for hostname, activity := range hostActivities(config, points, now) {
+		if activity.App != nil {
+			states[entityID(hostname, "current_app")] = HomeAssistantState{
+				State: activity.App.Tag("app"),
+				Attributes: map[string]any{
+					"friendly_name": hostname + " current app",
+					"last_event":    activity.App.Time.Format(time.RFC3339),
+				},
+			}
+		}
+		if activity.Afk == nil {
+			continue
+		}
+		if status := optionalField[string](*activity.Afk, "status"); status != nil {
+			states[entityID(hostname, "afk_status")] = HomeAssistantState{
+				State: *status,
+				Attributes: map[string]any{
+					"friendly_name": hostname + " AFK status",
+					"last_event":    activity.Afk.Time.Format(time.RFC3339),
+				},
+			}
+		}
+		states[entityID(hostname, "active_today")] = HomeAssistantState{
+			State: fmt.Sprintf("%d", int64(math.Round(activity.ActiveSeconds/60))),
+			Attributes: map[string]any{
+				"friendly_name":       hostname + " active today",
+				"unit_of_measurement": "min",
+				"state_class":         "measurement",
+			},
+		}
+	}
+	return states
+}
+
+func pushHomeAssistantStates(ctx context.Context, client *http.Client, config Config, points []Point) {
+	states := homeAssistantStates(config, points, time.Now())
+	for _, entity := range slices.Sorted(maps.Keys(states)) {
+		body, _ := json.Marshal(states[entity])
+		err := postHomeAssistantState(ctx, client, config, entity, body)
+		if err != nil {
+			log.Printf("Warning: unable to update the Home Assistant entity %s: %s\n", entity, err)
+			continue
+		}
+		debugf("Updated the Home Assistant entity %s to %s\n", entity, states[entity].State)
+	}
+}
+
+func postHomeAssistantState(ctx context.Context, client *http.Client, config Config, entity string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", config.HomeAssistantUrl+"/api/states/"+entity, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.HomeAssistantToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	return nil
+}
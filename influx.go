@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var influxV1Precisions = map[string]string{"s": "s", "ms": "ms", "us": "u", "ns": "ns"}
+
+var influxV3Precisions = map[string]string{"s": "second", "ms": "millisecond", "us": "microsecond", "ns": "nanosecond"}
+
+var lineNumberPattern = regexp.MustCompile(`(?:parsing|at) line (\d+)`)
+
+type RateLimitError struct {
+	Status     string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("error sending data: %s: %s", err.Status, err.Message)
+}
+
+var errPayloadTooLarge = errors.New("error sending data: 413 Request Entity Too Large")
+
+type LineProtocolError struct {
+	Status  string
+	Message string
+	Lines   []int
+}
+
+func (err *LineProtocolError) Error() string {
+	return fmt.Sprintf("error sending data: %s: %s", err.Status, err.Message)
+}
+
+func parseLineProtocolError(status string, body []byte) *LineProtocolError {
+	lpErr := &LineProtocolError{Status: status, Message: apiErrorMessage(body)}
+	var influxError struct {
+		Line int `json:"line"`
+		Data []struct {
+			LineNumber int `json:"line_number"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &influxError) == nil {
+		lpErr.Lines = append(lpErr.Lines, influxError.Line)
+		for _, data := range influxError.Data {
+			lpErr.Lines = append(lpErr.Lines, data.LineNumber)
+		}
+	}
+	for _, match := range lineNumberPattern.FindAllStringSubmatch(lpErr.Message, -1) {
+		line, _ := strconv.Atoi(match[1])
+		lpErr.Lines = append(lpErr.Lines, line)
+	}
+	lpErr.Lines = slices.DeleteFunc(lpErr.Lines, func(line int) bool {
+		return line <= 0
+	})
+	slices.Sort(lpErr.Lines)
+	lpErr.Lines = slices.Compact(lpErr.Lines)
+	return lpErr
+}
+
+func validateInfluxDB(config *Config) {
+	for _, status := range config.WriteSuccessStatus {
+		if status < 200 || status > 299 {
+			log.Fatalf("Invalid WriteSuccessStatus %d, must be a 2xx status code\n", status)
+		}
+	}
+	if config.MaxBatchBytes < 0 {
+		log.Fatalf("Invalid MaxBatchBytes %d, must be a positive number\n", config.MaxBatchBytes)
+	}
+	if config.WriteURL != "" {
+		writeUrl, err := url.Parse(config.WriteURL)
+		if err != nil || !slices.Contains([]string{"http", "https", udpScheme}, writeUrl.Scheme) || writeUrl.Host == "" {
+			log.Fatalf("Invalid WriteURL %q, must be an http, https or udp URL\n", config.WriteURL)
+		}
+		if config.UDPMaxDatagramSize == 0 {
+			config.UDPMaxDatagramSize = 1400
+		}
+		if config.UDPMaxDatagramSize < 0 {
+			log.Fatalf("Invalid UDPMaxDatagramSize %d, must be a positive number\n", config.UDPMaxDatagramSize)
+		}
+		if config.InfluxDBVersion == 0 {
+			config.InfluxDBVersion = 2
+		}
+		return
+	}
+	if config.InfluxDBHost == "" {
+		log.Fatalln("InfluxDBHost is required")
+	}
+	if strings.ContainsAny(config.InfluxDBHost, "/?#") {
+		log.Fatalf("Invalid InfluxDBHost %q, must be a host and optional port like influxdb.example.com:8086, without scheme or path\n", config.InfluxDBHost)
+	}
+	if config.InfluxDBVersion == 0 {
+		config.InfluxDBVersion = 2
+	}
+	switch config.InfluxDBVersion {
+	case 1:
+		if config.Bucket == "" {
+			log.Fatalln("Bucket is required")
+		}
+	case 2:
+		if config.Bucket == "" {
+			log.Fatalln("Bucket is required")
+		}
+		if config.InfluxDBApiToken == "" && config.InfluxDBUsername == "" {
+			log.Fatalln("InfluxDBApiToken or InfluxDBUsername and InfluxDBPassword are required")
+		}
+		if (config.Org == "") == (config.OrgID == "") {
+			log.Fatalln("Exactly one of Org or OrgID is required")
+		}
+		if config.BucketRetention != "" {
+			retention, err := time.ParseDuration(config.BucketRetention)
+			if err != nil || retention < time.Hour {
+				log.Fatalf("Invalid BucketRetention %q, must be a duration of at least 1h like 720h\n", config.BucketRetention)
+			}
+			config.bucketRetention = retention
+		}
+	case 3:
+		if config.Database == "" {
+			log.Fatalln("Database is required")
+		}
+		if config.InfluxDBApiToken == "" && config.InfluxDBUsername == "" {
+			log.Fatalln("InfluxDBApiToken or InfluxDBUsername and InfluxDBPassword are required")
+		}
+	default:
+		log.Fatalf("Invalid InfluxDBVersion %d, must be 1, 2 or 3\n", config.InfluxDBVersion)
+	}
+}
+
+func influxWriteUrl(config Config) string {
+	if config.WriteURL != "" {
+		return config.WriteURL
+	}
+	switch config.InfluxDBVersion {
+	case 1:
+		return influxApiUrl(config, "/write", url.Values{"precision": {influxV1Precisions[config.Precision]}, "db": {config.Bucket}})
+	case 3:
+		return influxApiUrl(config, "/api/v3/write_lp", url.Values{"precision": {influxV3Precisions[config.Precision]}, "db": {config.Database}})
+	default:
+		query := influxOrgQuery(config)
+		query.Set("precision", config.Precision)
+		query.Set("bucket", config.Bucket)
+		return influxApiUrl(config, "/api/v2/write", query)
+	}
+}
+
+type InfluxBucket struct {
+	Name string `json:"name"`
+}
+
+func influxApiUrl(config Config, path string, query url.Values) string {
+	apiUrl := url.URL{Scheme: "https", Host: config.InfluxDBHost, Path: path, RawQuery: query.Encode()}
+	return apiUrl.String()
+}
+
+func influxOrgQuery(config Config) url.Values {
+	if config.OrgID != "" {
+		return url.Values{"orgID": {config.OrgID}}
+	}
+	return url.Values{"org": {config.Org}}
+}
+
+func influxApiRequest(ctx context.Context, client *http.Client, config Config, method string, apiUrl string, body any, result any) (int, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return 0, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, method, apiUrl, &reqBody)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	setInfluxAuth(req, config)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("error reading data: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	return resp.StatusCode, json.Unmarshal(respBody, result)
+}
+
+func influxHTTPWrite(config Config) bool {
+	return config.Backend == backendInfluxDB && config.Format == "" && config.SocketPath == "" && !strings.HasPrefix(config.WriteURL, udpScheme+"://")
+}
+
+func checkInfluxToken(ctx context.Context, client *http.Client, config Config) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", influxWriteUrl(config), nil)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	setInfluxAuth(req, config)
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Warning: unable to check the InfluxDB token:", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := readBody(resp.Body, config.maxResponseSize)
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("InfluxDB authentication failed (401): %s", apiErrorMessage(body))
+	case http.StatusForbidden:
+		return fmt.Errorf("InfluxDB token has no write permission on the bucket (403): %s", apiErrorMessage(body))
+	}
+	debugf("InfluxDB token check returned %s\n", resp.Status)
+	return nil
+}
+
+func checkInfluxBucket(ctx context.Context, client *http.Client, config Config, dryRun bool) error {
+	query := influxOrgQuery(config)
+	query.Set("name", config.Bucket)
+	var buckets struct {
+		Buckets []InfluxBucket `json:"buckets"`
+	}
+	status, err := influxApiRequest(ctx, client, config, "GET", influxApiUrl(config, "/api/v2/buckets", query), nil, &buckets)
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		log.Printf("Warning: unable to check that the bucket %s exists, the token can't read the buckets API: %s\n", config.Bucket, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking the bucket %s: %w", config.Bucket, err)
+	}
+	if slices.ContainsFunc(buckets.Buckets, func(bucket InfluxBucket) bool {
+		return bucket.Name == config.Bucket
+	}) {
+		return nil
+	}
+	if !config.CreateBucket {
+		return fmt.Errorf("bucket not found: %s", config.Bucket)
+	}
+	if dryRun {
+		log.Printf("Bucket %s not found, it would be created\n", config.Bucket)
+		return nil
+	}
+	return createInfluxBucket(ctx, client, config)
+}
+
+func createInfluxBucket(ctx context.Context, client *http.Client, config Config) error {
+	orgID := config.OrgID
+	if orgID == "" {
+		var orgs struct {
+			Orgs []struct {
+				ID string `json:"id"`
+			} `json:"orgs"`
+		}
+		_, err := influxApiRequest(ctx, client, config, "GET", influxApiUrl(config, "/api/v2/orgs", url.Values{"org": {config.Org}}), nil, &orgs)
+		if err != nil {
+			return fmt.Errorf("error looking up the org %s: %w", config.Org, err)
+		}
+		if len(orgs.Orgs) == 0 {
+			return fmt.Errorf("org not found: %s", config.Org)
+		}
+		orgID = orgs.Orgs[0].ID
+	}
+	type retentionRule struct {
+		Type         string `json:"type"`
+		EverySeconds int64  `json:"everySeconds"`
+	}
+	bucket := struct {
+		OrgID          string          `json:"orgID"`
+		Name           string          `json:"name"`
+		RetentionRules []retentionRule `json:"retentionRules"`
+	}{OrgID: orgID, Name: config.Bucket, RetentionRules: []retentionRule{}}
+	if config.bucketRetention > 0 {
+		bucket.RetentionRules = append(bucket.RetentionRules, retentionRule{Type: "expire", EverySeconds: int64(config.bucketRetention.Seconds())})
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	_, err := influxApiRequest(ctx, client, config, "POST", influxApiUrl(config, "/api/v2/buckets", nil), bucket, &created)
+	if err != nil {
+		return fmt.Errorf("error creating the bucket %s: %w", config.Bucket, err)
+	}
+	log.Printf("Created the bucket %s with id %s\n", config.Bucket, created.ID)
+	return nil
+}
+
+func setInfluxAuth(req *http.Request, config Config) {
+	switch {
+	case config.InfluxDBUsername != "":
+		req.SetBasicAuth(config.InfluxDBUsername, config.InfluxDBPassword)
+	case config.InfluxDBVersion == 3 && config.InfluxDBApiToken != "":
+		req.Header.Set("Authorization", "Bearer "+config.InfluxDBApiToken)
+	case config.InfluxDBApiToken != "":
+		req.Header.Set("Authorization", "Token "+config.InfluxDBApiToken)
+	}
+}
+
+func maskedHeaders(header http.Header) http.Header {
+	masked := header.Clone()
+	for _, key := range []string{"Authorization", "Proxy-Authorization"} {
+		if value := masked.Get(key); value != "" {
+			scheme, _, _ := strings.Cut(value, " ")
+			masked.Set(key, scheme+" ***")
+		}
+	}
+	return masked
+}
+
+func postLineProtocol(ctx context.Context, client *http.Client, config Config, url string, payload []byte, setAuth func(*http.Request), success ...int) error {
+	var buf bytes.Buffer
+	if config.DisableGzip {
+		buf.Write(payload)
+	} else {
+		w, err := gzip.NewWriterLevel(&buf, config.compressionLevel)
+		if err != nil {
+			return fmt.Errorf("error compressing data: %w", err)
+		}
+		w.Write(payload)
+		err = w.Close()
+		if err != nil {
+			return fmt.Errorf("error compressing data: %w", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	post, _ := http.NewRequestWithContext(ctx, "POST", url, &buf)
+	post.Header.Set("Accept", "application/json")
+	setAuth(post)
+	if !config.DisableGzip {
+		post.Header.Set("Content-Encoding", "gzip")
+	}
+	post.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	debugf("Sending %d bytes, %d once compressed, to %s with headers %s\n", len(payload), buf.Len(), url, maskedHeaders(post.Header))
+	resp, err := client.Do(post)
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return fmt.Errorf("error reading data: %w", err)
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		return parseLineProtocolError(resp.Status, body)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{Status: resp.Status, Message: apiErrorMessage(body), RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return fmt.Errorf("%w: %s", errPayloadTooLarge, apiErrorMessage(body))
+	}
+	if !slices.Contains(success, resp.StatusCode) {
+		return fmt.Errorf("error sending data: %s: %s", resp.Status, apiErrorMessage(body))
+	}
+	return nil
+}
+
+func postInfluxDB(ctx context.Context, client *http.Client, config Config, payload []byte) error {
+	success := []int{http.StatusNoContent}
+	if config.InfluxDBVersion == 3 {
+		success = append(success, http.StatusOK)
+	}
+	if len(config.WriteSuccessStatus) > 0 {
+		success = config.WriteSuccessStatus
+	}
+	return postLineProtocol(ctx, client, config, influxWriteUrl(config), payload, func(req *http.Request) {
+		setInfluxAuth(req, config)
+	}, success...)
+}
+
+func splitPayload(payload []byte) ([]byte, []byte, bool) {
+	middle := len(payload) / 2
+	if i := bytes.IndexByte(payload[middle:], '\n'); i >= 0 && middle+i+1 < len(payload) {
+		return payload[:middle+i+1], payload[middle+i+1:], true
+	}
+	if i := bytes.LastIndexByte(payload[:middle], '\n'); i >= 0 {
+		return payload[:i+1], payload[i+1:], true
+	}
+	return nil, nil, false
+}
+
+type InfluxWriteStats struct {
+	Requests    int
+	RateLimited int
+	Waited      time.Duration
+	pause       time.Duration
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func postInfluxDBPaced(ctx context.Context, client *http.Client, config Config, payload []byte, stats *InfluxWriteStats) error {
+	var wait time.Duration
+	if stats.Requests > 0 {
+		wait = stats.pause
+	}
+	for attempt := 0; ; attempt++ {
+		if wait > 0 {
+			err := sleepContext(ctx, wait)
+			if err != nil {
+				return err
+			}
+			stats.Waited += wait
+		}
+		stats.Requests++
+		err := postInfluxDB(ctx, client, config, payload)
+		var rateLimitErr *RateLimitError
+		if !errors.As(err, &rateLimitErr) || attempt == retryCount {
+			return err
+		}
+		wait = rateLimitErr.RetryAfter
+		if wait <= 0 {
+			wait = time.Duration(1<<attempt) * time.Second
+		}
+		wait = min(wait, config.rateLimitMaxWait)
+		stats.pause = wait
+		stats.RateLimited++
+		log.Printf("Rate limited by InfluxDB, waiting %s before retrying\n", wait)
+	}
+}
+
+func writeInfluxDBChunk(ctx context.Context, client *http.Client, config Config, payload []byte, offset int, stats *InfluxWriteStats) error {
+	err := postInfluxDBPaced(ctx, client, config, payload, stats)
+	var lpErr *LineProtocolError
+	if errors.As(err, &lpErr) {
+		for i := range lpErr.Lines {
+			lpErr.Lines[i] += offset
+		}
+	}
+	if !errors.Is(err, errPayloadTooLarge) {
+		return err
+	}
+	first, second, ok := splitPayload(payload)
+	if !ok {
+		return err
+	}
+	debugf("Payload of %d bytes too large, splitting it in two\n", len(payload))
+	err = writeInfluxDBChunk(ctx, client, config, first, offset, stats)
+	if err != nil {
+		return err
+	}
+	return writeInfluxDBChunk(ctx, client, config, second, offset+bytes.Count(first, []byte("\n")), stats)
+}
+
+func writeInfluxDB(ctx context.Context, client *http.Client, config Config, payload []byte, stats *InfluxWriteStats) error {
+	chunkSize := len(payload)
+	if config.MaxBatchBytes > 0 {
+		chunkSize = config.MaxBatchBytes
+	}
+	var offset int
+	for _, chunk := range socketChunks(payload, chunkSize) {
+		err := writeInfluxDBChunk(ctx, client, config, chunk, offset, stats)
+		if err != nil {
+			return err
+		}
+		offset += bytes.Count(chunk, []byte("\n"))
+	}
+	return nil
+}
+
+// InfluxBatchWriter writes the points to InfluxDB in batches of BatchSize as they are added,
+// a failed batch is spooled without stopping the next ones
+type InfluxBatchWriter struct {
+	client  *http.Client
+	config  Config
+	stats   InfluxWriteStats
+	pending []Point
+	batches int
+	failed  int
+	written int
+	errs    []error
+}
+
+func newInfluxBatchWriter(client *http.Client, config Config) *InfluxBatchWriter {
+	return &InfluxBatchWriter{client: client, config: config}
+}
+
+// Write writes the full batches of the pending and added points and keeps the rest pending,
+// the added points are only copied when they don't fill a batch
+func (writer *InfluxBatchWriter) Write(ctx context.Context, points []Point) {
+	size := writer.config.BatchSize
+	for len(writer.pending)+len(points) >= size {
+		if len(writer.pending) == 0 {
+			writer.writeBatch(ctx, points[:size])
+			points = points[size:]
+			continue
+		}
+		missing := size - len(writer.pending)
+		writer.pending = append(writer.pending, points[:missing]...)
+		writer.writeBatch(ctx, writer.pending)
+		writer.pending = writer.pending[:0]
+		points = points[missing:]
+	}
+	writer.pending = append(writer.pending, points...)
+}
+
+// Close writes the last partial batch and returns the bytes written and the errors of the failed batches
+func (writer *InfluxBatchWriter) Close(ctx context.Context) (int, error) {
+	if len(writer.pending) > 0 {
+		writer.writeBatch(ctx, writer.pending)
+		writer.pending = nil
+	}
+	log.Printf("Wrote %d of %d batches and %d bytes to InfluxDB in %d write requests, rate limited %d times for a total wait of %s\n", writer.batches-writer.failed, writer.batches, writer.written, writer.stats.Requests, writer.stats.RateLimited, writer.stats.Waited)
+	return writer.written, errors.Join(writer.errs...)
+}
+
+func (writer *InfluxBatchWriter) writeBatch(ctx context.Context, points []Point) {
+	writer.batches++
+	sortPoints(points)
+	payload, err := writeInfluxDBBatch(ctx, writer.client, writer.config, points, &writer.stats)
+	if err == nil {
+		writer.written += len(payload)
+		return
+	}
+	writer.failed++
+	if spoolEnabled(writer.config) {
+		name, spoolErr := spoolPayload(writer.config, payload)
+		if spoolErr != nil {
+			err = fmt.Errorf("%w, unable to spool the payload: %s", err, spoolErr)
+		} else {
+			err = fmt.Errorf("%w, spooled the payload to %s", err, name)
+		}
+	}
+	writer.errs = append(writer.errs, err)
+}
+
+func writeInfluxDBPoints(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	writer := newInfluxBatchWriter(client, config)
+	writer.Write(ctx, points)
+	return writer.Close(ctx)
+}
+
+func writeInfluxDBBatch(ctx context.Context, client *http.Client, config Config, points []Point, stats *InfluxWriteStats) ([]byte, error) {
+	payload := linesPayload(points, config.precision)
+	err := writeInfluxDB(ctx, client, config, payload, stats)
+	var lpErr *LineProtocolError
+	if !errors.As(err, &lpErr) || len(lpErr.Lines) == 0 {
+		return payload, err
+	}
+	var bad []int
+	for _, line := range lpErr.Lines {
+		if line > len(points) {
+			continue
+		}
+		point := points[line-1]
+		log.Printf("Rejected line %d from bucket=%s type=%s: %s\n", line, point.BucketID, point.Measurement, strings.TrimSuffix(point.LineProtocol(config.precision), "\n"))
+		bad = append(bad, line-1)
+	}
+	if !config.skipBadLines || len(bad) == 0 {
+		return payload, err
+	}
+	var kept []Point
+	for i, point := range points {
+		if !slices.Contains(bad, i) {
+			kept = append(kept, point)
+		}
+	}
+	log.Printf("Skipping %d bad lines and retrying the write\n", len(bad))
+	payload = linesPayload(kept, config.precision)
+	return payload, writeInfluxDB(ctx, client, config, payload, stats)
+}
+
+func writeVictoriaMetrics(ctx context.Context, client *http.Client, config Config, payload []byte) error {
+	return postLineProtocol(ctx, client, config, config.VictoriaMetricsUrl+"/write?precision="+influxV1Precisions[config.Precision], payload, func(req *http.Request) {
+		if config.InfluxDBUsername != "" {
+			req.SetBasicAuth(config.InfluxDBUsername, config.InfluxDBPassword)
+		}
+	}, http.StatusNoContent, http.StatusOK)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type influxRequest struct {
+	path          string
+	query         url.Values
+	authorization string
+	body          string
+}
+
+func newInfluxStub(t *testing.T, status int, requests *[]influxRequest) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("error reading the gzip body: %s", err)
+				return
+			}
+			body = reader
+		}
+		data, _ := io.ReadAll(body)
+		*requests = append(*requests, influxRequest{path: r.URL.Path, query: r.URL.Query(), authorization: r.Header.Get("Authorization"), body: string(data)})
+		w.WriteHeader(status)
+	}))
+}
+
+func testInfluxConfig(server *httptest.Server) Config {
+	return Config{
+		Backend:          backendInfluxDB,
+		InfluxDBHost:     strings.TrimPrefix(server.URL, "https://"),
+		Bucket:           "activitywatch",
+		Precision:        "s",
+		BatchSize:        1000,
+		writeTimeout:     5 * time.Second,
+		maxResponseSize:  1 << 20,
+		compressionLevel: gzip.DefaultCompression,
+		precision:        time.Second,
+	}
+}
+
+func TestPostInfluxDBVersions(t *testing.T) {
+	payload := "currentwindow,hostname=laptop,app=Code duration=30.000 1741944413\n"
+	tests := []struct {
+		name          string
+		version       int
+		username      string
+		token         string
+		precision     string
+		status        int
+		path          string
+		query         url.Values
+		authorization string
+		err           bool
+	}{
+		{"v1 basic auth", 1, "writer", "", "s", http.StatusNoContent, "/write", url.Values{"db": {"activitywatch"}, "precision": {"s"}}, "Basic d3JpdGVyOnNlY3JldA==", false},
+		{"v1 without auth", 1, "", "", "us", http.StatusNoContent, "/write", url.Values{"db": {"activitywatch"}, "precision": {"u"}}, "", false},
+		{"v1 org ignored", 1, "", "", "ms", http.StatusNoContent, "/write", url.Values{"db": {"activitywatch"}, "precision": {"ms"}}, "", false},
+		{"v1 unauthorized", 1, "writer", "", "s", http.StatusUnauthorized, "/write", url.Values{"db": {"activitywatch"}, "precision": {"s"}}, "Basic d3JpdGVyOnNlY3JldA==", true},
+		{"v1 ok is not a write", 1, "", "", "s", http.StatusOK, "/write", url.Values{"db": {"activitywatch"}, "precision": {"s"}}, "", true},
+		{"v2 token", 2, "", "token", "s", http.StatusNoContent, "/api/v2/write", url.Values{"bucket": {"activitywatch"}, "org": {"home"}, "precision": {"s"}}, "Token token", false},
+		{"v3 token", 3, "", "token", "ns", http.StatusOK, "/api/v3/write_lp", url.Values{"db": {"activitywatch"}, "precision": {"nanosecond"}}, "Bearer token", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []influxRequest
+			server := newInfluxStub(t, test.status, &requests)
+			defer server.Close()
+			config := testInfluxConfig(server)
+			config.InfluxDBVersion = test.version
+			config.InfluxDBUsername = test.username
+			config.InfluxDBPassword = "secret"
+			config.InfluxDBApiToken = test.token
+			config.Precision = test.precision
+			config.Org = "home"
+			config.Database = "activitywatch"
+			err := postInfluxDB(t.Context(), server.Client(), config, []byte(payload))
+			if (err != nil) != test.err {
+				t.Errorf("postInfluxDB() error = %v, want error=%t", err, test.err)
+			}
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			request := requests[0]
+			if request.path != test.path || request.query.Encode() != test.query.Encode() {
+				t.Errorf("wrote to %s?%s, want %s?%s", request.path, request.query.Encode(), test.path, test.query.Encode())
+			}
+			if request.authorization != test.authorization {
+				t.Errorf("Authorization = %q, want %q", request.authorization, test.authorization)
+			}
+			if request.body != payload {
+				t.Errorf("body = %q, want %q", request.body, payload)
+			}
+		})
+	}
+}
+
+func TestInfluxWriteUrlOrg(t *testing.T) {
+	tests := []struct {
+		name   string
+		org    string
+		orgID  string
+		bucket string
+		want   string
+	}{
+		{"org", "home", "", "activitywatch", "https://influxdb:8086/api/v2/write?bucket=activitywatch&org=home&precision=s"},
+		{"org with spaces", "my home lab", "", "activitywatch", "https://influxdb:8086/api/v2/write?bucket=activitywatch&org=my+home+lab&precision=s"},
+		{"org with plus signs", "c++ & go", "", "activitywatch", "https://influxdb:8086/api/v2/write?bucket=activitywatch&org=c%2B%2B+%26+go&precision=s"},
+		{"org id", "", "0123456789abcdef", "activitywatch", "https://influxdb:8086/api/v2/write?bucket=activitywatch&orgID=0123456789abcdef&precision=s"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Config{InfluxDBHost: "influxdb:8086", InfluxDBVersion: 2, Org: test.org, OrgID: test.orgID, Bucket: test.bucket, Precision: "s"}
+			got := influxWriteUrl(config)
+			if got != test.want {
+				t.Errorf("influxWriteUrl() = %q, want %q", got, test.want)
+			}
+			writeUrl, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := writeUrl.Query()
+			if query.Get("org") != test.org || query.Get("orgID") != test.orgID || query.Get("bucket") != test.bucket {
+				t.Errorf("influxWriteUrl() decodes to org=%q orgID=%q bucket=%q", query.Get("org"), query.Get("orgID"), query.Get("bucket"))
+			}
+		})
+	}
+}
+
+func TestInfluxWriteUrlEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  int
+		org      string
+		bucket   string
+		database string
+		want     string
+	}{
+		{"v2 unicode org", 2, "Zürich 日本", "activitywatch", "", "https://influxdb:8086/api/v2/write?bucket=activitywatch&org=Z%C3%BCrich+%E6%97%A5%E6%9C%AC&precision=s"},
+		{"v2 bucket with slashes", 2, "home", "activitywatch/autogen", "", "https://influxdb:8086/api/v2/write?bucket=activitywatch%2Fautogen&org=home&precision=s"},
+		{"v2 bucket with query characters", 2, "home", "aw&week#1", "", "https://influxdb:8086/api/v2/write?bucket=aw%26week%231&org=home&precision=s"},
+		{"v1 database with slashes", 1, "", "activitywatch/autogen", "", "https://influxdb:8086/write?db=activitywatch%2Fautogen&precision=s"},
+		{"v1 database with spaces", 1, "", "activity watch", "", "https://influxdb:8086/write?db=activity+watch&precision=s"},
+		{"v3 database with plus signs", 3, "", "", "c++", "https://influxdb:8086/api/v3/write_lp?db=c%2B%2B&precision=second"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Config{InfluxDBHost: "influxdb:8086", InfluxDBVersion: test.version, Org: test.org, Bucket: test.bucket, Database: test.database, Precision: "s"}
+			got := influxWriteUrl(config)
+			if got != test.want {
+				t.Errorf("influxWriteUrl() = %q, want %q", got, test.want)
+			}
+			writeUrl, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := writeUrl.Query()
+			if query.Get("org") != test.org || query.Get("bucket")+query.Get("db") != test.bucket+test.database {
+				t.Errorf("influxWriteUrl() decodes to org=%q bucket=%q db=%q", query.Get("org"), query.Get("bucket"), query.Get("db"))
+			}
+		})
+	}
+}
+
+func TestPostLineProtocolCompression(t *testing.T) {
+	var payload strings.Builder
+	for i := range 500 {
+		fmt.Fprintf(&payload, "currentwindow,hostname=laptop,app=Code title=\"main.go %d\",duration=%d.000 %d\n", i%7, i%60, 1741944413+i)
+	}
+	level := func(level int) *int {
+		return &level
+	}
+	tests := []struct {
+		name        string
+		level       *int
+		disableGzip bool
+		encoding    string
+	}{
+		{"uncompressed", nil, true, ""},
+		{"no compression", level(gzip.NoCompression), false, "gzip"},
+		{"best speed", level(gzip.BestSpeed), false, "gzip"},
+		{"default", nil, false, "gzip"},
+		{"best compression", level(gzip.BestCompression), false, "gzip"},
+	}
+	sizes := make([]int, len(tests))
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var encoding, body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				sizes[i] = len(data)
+				encoding = r.Header.Get("Content-Encoding")
+				body = string(data)
+				if encoding == "gzip" {
+					reader, err := gzip.NewReader(bytes.NewReader(data))
+					if err != nil {
+						t.Errorf("error reading the gzip body: %s", err)
+						return
+					}
+					data, _ = io.ReadAll(reader)
+					body = string(data)
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+			config := Config{writeTimeout: 5 * time.Second, maxResponseSize: 1 << 20, compressionLevel: gzip.DefaultCompression, DisableGzip: test.disableGzip}
+			if test.level != nil {
+				config.compressionLevel = *test.level
+			}
+			err := postLineProtocol(t.Context(), server.Client(), config, server.URL, []byte(payload.String()), func(*http.Request) {}, http.StatusNoContent)
+			if err != nil {
+				t.Fatalf("postLineProtocol() error = %s", err)
+			}
+			if encoding != test.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", encoding, test.encoding)
+			}
+			if body != payload.String() {
+				t.Errorf("received a %d bytes body different from the %d bytes payload", len(body), payload.Len())
+			}
+		})
+	}
+	// the gzip container makes the level 0 payload slightly larger than the uncompressed one
+	if !(sizes[1] > sizes[0] && sizes[0] > sizes[2] && sizes[2] > sizes[3] && sizes[3] >= sizes[4]) {
+		t.Errorf("payload sizes %v, want them decreasing from no compression to the best compression", sizes)
+	}
+}
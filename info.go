@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const infoApiPath = "/api/0/info"
+
+const discoverUrl = "auto"
+
+const (
+	flavorPython = "aw-server"
+	flavorRust   = "aw-server-rust"
+)
+
+var discoveryUrls = []string{"http://localhost:5600", "http://localhost:5666"}
+
+type ServerInfo struct {
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+	Testing  bool   `json:"testing"`
+	DeviceID string `json:"device_id"`
+}
+
+func fetchServerInfo(ctx context.Context, client *http.Client, config Config) (ServerInfo, error) {
+	var info ServerInfo
+	ctx, cancel := context.WithTimeout(ctx, config.bucketListTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", activityWatchApiUrl(config, infoApiPath), nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return info, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("unexpected status %s: %s", resp.Status, apiErrorMessage(body))
+	}
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return info, fmt.Errorf("error unmarshalling server info: %w", err)
+	}
+	return info, nil
+}
+
+func (info ServerInfo) Flavor() string {
+	if strings.Contains(strings.ToLower(info.Version), "rust") {
+		return flavorRust
+	}
+	return flavorPython
+}
+
+func apiErrorMessage(body []byte) string {
+	var apiError struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &apiError) == nil {
+		var parts []string
+		for _, part := range []string{apiError.Reason, apiError.Message, apiError.Error} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, ": ")
+		}
+	}
+	message := strings.TrimSpace(string(body))
+	if len(message) > 512 {
+		message = message[:512] + "..."
+	}
+	return message
+}
+
+func probeServer(ctx context.Context, client *http.Client, baseUrl string) bool {
+	req, _ := http.NewRequestWithContext(ctx, "GET", baseUrl+infoApiPath, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func discoverActivityWatchUrl(ctx context.Context) (string, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	var found []string
+	for _, candidate := range discoveryUrls {
+		if probeServer(ctx, client, candidate) {
+			found = append(found, candidate)
+		}
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("no ActivityWatch server found at %s", strings.Join(discoveryUrls, " or "))
+	}
+	if len(found) > 1 {
+		log.Printf("Warning: ActivityWatch servers found at %s, using %s\n", strings.Join(found, " and "), found[0])
+	}
+	return found[0], nil
+}
+
+func serverInfoPoint(config Config, info ServerInfo, timestamp time.Time) Point {
+	hostname := resolveHostname(config, info.Hostname)
+	if hostname == "" {
+		hostname = "unknown"
+	}
+	version := info.Version
+	if version == "" {
+		version = "unknown"
+	}
+	point := Point{Measurement: "aw_server_info", Time: timestamp}
+	point.AddTag("hostname", hostname)
+	point.AddTag("version", version)
+	point.AddField("testing", info.Testing)
+	return point
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActivityWatchApiUrl(t *testing.T) {
+	tests := []struct {
+		baseUrl string
+		elem    []string
+		want    string
+	}{
+		{"http://localhost:5600", []string{bucketsApiPath}, "http://localhost:5600/api/0/buckets"},
+		{"http://localhost:5600/", []string{bucketsApiPath}, "http://localhost:5600/api/0/buckets"},
+		{"http://localhost:5600//", []string{infoApiPath}, "http://localhost:5600/api/0/info"},
+		{"https://example.org/aw/", []string{bucketsApiPath, "aw-watcher-window_laptop", "events"}, "https://example.org/aw/api/0/buckets/aw-watcher-window_laptop/events"},
+		{"https://example.org/activitywatch", []string{infoApiPath}, "https://example.org/activitywatch/api/0/info"},
+		{"http://localhost:5600", []string{bucketsApiPath, url.PathEscape("aw-watcher-web/laptop"), "events"}, "http://localhost:5600/api/0/buckets/aw-watcher-web%2Flaptop/events"},
+	}
+	for _, test := range tests {
+		t.Run(test.baseUrl, func(t *testing.T) {
+			if got := activityWatchApiUrl(Config{ActivityWatchUrl: test.baseUrl}, test.elem...); got != test.want {
+				t.Errorf("activityWatchApiUrl(%q, %q) = %q, want %q", test.baseUrl, test.elem, got, test.want)
+			}
+		})
+	}
+}
+
+func TestApiErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"aw-server", `{"message": "There's no bucket named aw-watcher-afk_laptop", "type": "BucketNotFound"}`, "There's no bucket named aw-watcher-afk_laptop"},
+		{"aw-server-rust", `{"message": "There's no bucket named aw-watcher-afk_laptop"}`, "There's no bucket named aw-watcher-afk_laptop"},
+		{"rocket catcher", `{"error": {"code": 404}}`, `{"error": {"code": 404}}`},
+		{"reason and message", `{"reason": "Not Found", "message": "no route"}`, "Not Found: no route"},
+		{"error string", `{"error": "invalid timeperiod"}`, "invalid timeperiod"},
+		{"html", "<html><body>404 Not Found</body></html>\n", "<html><body>404 Not Found</body></html>"},
+		{"long text", strings.Repeat("a", 600), strings.Repeat("a", 512) + "..."},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := apiErrorMessage([]byte(test.body)); got != test.want {
+				t.Errorf("apiErrorMessage(%q) = %q, want %q", test.body, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFetchServerInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		flavor string
+		err    bool
+	}{
+		{"aw-server", http.StatusOK, `{"hostname": "laptop", "version": "v0.12.2", "testing": false, "device_id": "5f1b"}`, flavorPython, false},
+		{"aw-server-rust", http.StatusOK, `{"hostname": "laptop", "version": "v0.13.1 (rust)", "testing": false, "device_id": "5f1b"}`, flavorRust, false},
+		{"missing version", http.StatusOK, `{"hostname": "laptop"}`, flavorPython, false},
+		{"error", http.StatusInternalServerError, `{"message": "database locked"}`, "", true},
+		{"not json", http.StatusOK, "<html></html>", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var path string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+			config := Config{ActivityWatchUrl: server.URL + "/", bucketListTimeout: 5 * time.Second, maxResponseSize: 1 << 20}
+			info, err := fetchServerInfo(t.Context(), server.Client(), config)
+			if path != infoApiPath {
+				t.Errorf("requested %q, want %q", path, infoApiPath)
+			}
+			if (err != nil) != test.err {
+				t.Fatalf("fetchServerInfo() error = %v, want error=%t", err, test.err)
+			}
+			if err == nil && info.Flavor() != test.flavor {
+				t.Errorf("Flavor() = %q, want %q", info.Flavor(), test.flavor)
+			}
+		})
+	}
+}
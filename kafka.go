@@ -0,0 +1,79 @@
+//go:build kafka
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const kafkaEnabled = true
+
+func kafkaMechanism(config Config) (sasl.Mechanism, error) {
+	switch config.KafkaSASLMechanism {
+	case kafkaSASLPlain:
+		return plain.Mechanism{Username: config.KafkaUsername, Password: config.KafkaPassword}, nil
+	case kafkaSASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, config.KafkaUsername, config.KafkaPassword)
+	case kafkaSASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, config.KafkaUsername, config.KafkaPassword)
+	default:
+		return nil, nil
+	}
+}
+
+func writeKafka(ctx context.Context, config Config, points []Point) (int, error) {
+	mechanism, err := kafkaMechanism(config)
+	if err != nil {
+		return 0, fmt.Errorf("error configuring the Kafka SASL mechanism: %w", err)
+	}
+	transport := &kafka.Transport{SASL: mechanism}
+	if config.KafkaTLS {
+		transport.TLS = &tls.Config{}
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(config.KafkaBrokers...),
+		Topic:        config.KafkaTopic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    config.BatchSize,
+		BatchTimeout: 100 * time.Millisecond,
+		RequiredAcks: kafka.RequireAll,
+		WriteTimeout: config.writeTimeout,
+		Transport:    transport,
+	}
+	var written int
+	messages := make([]kafka.Message, 0, len(points))
+	for _, point := range points {
+		value, err := json.Marshal(point)
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, kafka.Message{Key: []byte(point.Tag("hostname")), Value: value, Time: point.Time})
+		written += len(value)
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	err = writer.WriteMessages(ctx, messages...)
+	closeErr := writer.Close()
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		return written, fmt.Errorf("error sending data: %d of %d messages failed: %w", writeErrors.Count(), len(messages), errors.Join(writeErrors...))
+	}
+	if err != nil {
+		return written, fmt.Errorf("error sending data: %w", err)
+	}
+	if closeErr != nil {
+		return written, fmt.Errorf("error flushing the Kafka producer: %w", closeErr)
+	}
+	debugf("Produced %d messages to the %s topic\n", len(messages), config.KafkaTopic)
+	return written, nil
+}
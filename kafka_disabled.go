@@ -0,0 +1,14 @@
+//go:build !kafka
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+const kafkaEnabled = false
+
+func writeKafka(ctx context.Context, config Config, points []Point) (int, error) {
+	return 0, errors.New("built without Kafka support")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func bucketPoints(config Config, entry Bucket, events []Event, summary *Summary) []Point {
+	var points []Point
+	seriesTags := []Tag{{Key: "client", Value: resolveClient(config, entry.Client)}}
+	seriesTags = append(seriesTags, hostTags(config, entry.Hostname)...)
+	if config.IncludeBucketIDTag {
+		seriesTags = append(seriesTags, Tag{Key: "bucket", Value: entry.ID})
+	}
+	location := hostnameLocation(config, entry.Hostname)
+	filteredApps := make(map[string]int)
+	for _, event := range events {
+		if len(event.Data) == 0 {
+			event.Data = json.RawMessage("{}")
+		}
+		if config.SkipZeroDuration && event.Duration == 0 && (entry.Type != stopwatchType || config.SkipZeroDurationStopwatch) {
+			summary.ZeroDuration.Add(1)
+			continue
+		}
+		var tags []Tag
+		var fields []Field
+		var title string
+		categoryValues := make(map[string]string)
+		switch entry.Type {
+		case webTabCurrentType:
+			data := new(WebTabCurrent)
+			err := json.Unmarshal(event.Data, data)
+			if err != nil {
+				log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
+				continue
+			}
+			if config.ExcludeIncognito && data.Incognito {
+				summary.ExcludedIncognito.Add(1)
+				continue
+			}
+			u, err := url.Parse(redactValue(config.RedactionRules, "url", data.URL, &summary.Redactions))
+			if err != nil {
+				log.Printf("Error parsing URL=%s: %s\n", data.URL, err)
+				continue
+			}
+			if !isDomainExported(u.Hostname(), config.WebDomainAllowlist, config.WebDomainBlocklist) {
+				summary.FilteredDomains.Add(1)
+				continue
+			}
+			if u.Host != "" {
+				host := normalizeHost(u, config.KeepWwwPrefix)
+				categoryValues["url"] = host
+				tags = append(tags, Tag{Key: "url", Value: protectValue(config, "url", host)})
+				if !config.DisableDomainTag {
+					domain := registrableDomain(strings.ToLower(u.Hostname()))
+					tags = append(tags, Tag{Key: "domain", Value: protectValue(config, "domain", domain)})
+				}
+			}
+			if config.BrowserTag {
+				tags = append(tags, Tag{Key: "browser", Value: browserName(entry.Client, entry.ID)})
+			}
+			title = redactValue(config.RedactionRules, "title", data.Title, &summary.Redactions)
+			categoryValues["title"] = title
+			fields = []Field{{Key: "audible", Value: data.Audible}, {Key: "incognito", Value: data.Incognito}}
+		case appEditorType:
+			data := new(AppEditorActivity)
+			err := json.Unmarshal(event.Data, data)
+			if err != nil {
+				log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
+				continue
+			}
+			data.Project = redactValue(config.RedactionRules, "project", data.Project, &summary.Redactions)
+			data.File = sanitizeString(redactValue(config.RedactionRules, "file", data.File, &summary.Redactions))
+			if data.File == "" {
+				debugf("Skipping event id=%d of bucket=%s without file\n", event.ID, entry.ID)
+				continue
+			}
+			categoryValues["project"] = data.Project
+			tags = []Tag{
+				{Key: "project", Value: data.Project},
+				{Key: "language", Value: data.Language},
+				{Key: "file", Value: protectValue(config, "file", editorFile(data.File, config.EditorFileDetail, config.ProjectRoots))},
+			}
+		case currentWindowType:
+			data := new(CurrentWindow)
+			err := json.Unmarshal(event.Data, data)
+			if err != nil {
+				log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
+				continue
+			}
+			if !isAppExported(data.App, config.AppAllowlist, config.AppBlocklist) {
+				filteredApps[data.App]++
+				summary.FilteredApps.Add(1)
+				continue
+			}
+			data.App = sanitizeString(redactValue(config.RedactionRules, "app", data.App, &summary.Redactions))
+			if data.App == "" {
+				debugf("Skipping event id=%d of bucket=%s without app\n", event.ID, entry.ID)
+				continue
+			}
+			categoryValues["app"] = data.App
+			title = redactValue(config.RedactionRules, "title", data.Title, &summary.Redactions)
+			categoryValues["title"] = title
+			tags = []Tag{{Key: "app", Value: data.App}}
+		case stopwatchType:
+			data := new(StopWatch)
+			err := json.Unmarshal(event.Data, data)
+			if err != nil {
+				log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
+				continue
+			}
+			data.Label = redactValue(config.RedactionRules, "label", data.Label, &summary.Redactions)
+			tags = []Tag{{Key: "label", Value: protectValue(config, "label", data.Label)}}
+			fields = []Field{{Key: "running", Value: data.Running}}
+		case afkType:
+			data := new(AfkStatus)
+			err := json.Unmarshal(event.Data, data)
+			if err != nil {
+				log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
+				continue
+			}
+			if data.Status == "" {
+				debugf("Skipping event id=%d of bucket=%s without status\n", event.ID, entry.ID)
+				continue
+			}
+			fields = []Field{{Key: "status", Value: data.Status}}
+		default:
+			log.Printf("Skipping unknown event type: %s\n", entry.Type)
+			continue
+		}
+
+		point := Point{Measurement: entry.Type, Time: event.Timestamp, BucketID: entry.ID, EventID: event.ID, Title: protectValue(config, "title", title)}
+		if config.Backend == backendLoki && config.Format == "" {
+			point.Data = lokiEventData(config, event.Data)
+		}
+		point.AddTags(seriesTags...)
+		point.AddTags(tags...)
+		if len(config.CategoryRules) > 0 {
+			point.AddTags(categoryTags(categorize(config.CategoryRules, categoryValues), config.CategorySeparator, config.SubcategoryTag)...)
+		}
+		local := event.Timestamp.In(location)
+		if config.TimeBreakdownTags {
+			point.AddTag("weekday", local.Format("Mon"))
+			point.AddTag("hour", fmt.Sprintf("%02d", local.Hour()))
+		}
+		point.Fields = append(durationFields(event.Duration, config.DurationUnit), fields...)
+		if config.LocalTimeField {
+			point.AddField("local_time", local.Format(time.RFC3339))
+		}
+		if config.IncludeEventID {
+			point.AddField("event_id", int64(event.ID))
+		}
+		end := eventEnd(event)
+		if config.IncludeEndTimestamp {
+			// in the precision the backend writes the timestamps with, so end matches the time of the point
+			point.AddField("end", end.UnixNano()/int64(writePrecision(config)))
+		}
+		if config.TimestampAt == timestampAtEnd {
+			point.Time = end
+		}
+		points = append(points, point)
+		summary.Events.Add(1)
+	}
+	for app, count := range filteredApps {
+		debugf("Filtered %d events of app=%s from bucket=%s\n", count, app, entry.ID)
+	}
+	return points
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2025, time.March, 14, 9, 26, 53, 0, time.UTC)
+
+func testEvents(data ...string) []Event {
+	var events []Event
+	for i, eventData := range data {
+		events = append(events, Event{ID: i + 1, Timestamp: testTime.Add(time.Duration(i) * time.Minute), Duration: 30, Data: json.RawMessage(eventData)})
+	}
+	return events
+}
+
+func testBucket(bucketType string) Bucket {
+	return Bucket{ID: bucketType + "_laptop", Type: bucketType, Client: "aw-watcher", Hostname: "laptop"}
+}
+
+func TestBucketPointsHashedTags(t *testing.T) {
+	config := Config{location: time.UTC, HashSensitiveValues: true, HashSalt: "salt", HashedFields: defaultHashedFields}
+	tests := []struct {
+		name       string
+		bucketType string
+		tag        string
+		data       []string
+		same       bool
+	}{
+		{"same url", webTabCurrentType, "url", []string{`{"url":"https://github.com/a"}`, `{"url":"https://www.GitHub.com/b"}`}, true},
+		{"other url", webTabCurrentType, "url", []string{`{"url":"https://github.com/a"}`, `{"url":"https://gitlab.com/a"}`}, false},
+		{"same domain", webTabCurrentType, "domain", []string{`{"url":"https://docs.github.com/"}`, `{"url":"https://api.github.com/"}`}, true},
+		{"same file", appEditorType, "file", []string{`{"file":"/src/main.go","project":"a"}`, `{"file":"/src/main.go","project":"b"}`}, true},
+		{"same label", stopwatchType, "label", []string{`{"label":"writing, reviewing"}`, `{"label":"writing, reviewing"}`}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points := bucketPoints(config, testBucket(test.bucketType), testEvents(test.data...), &Summary{})
+			if len(points) != 2 {
+				t.Fatalf("got %d points, want 2", len(points))
+			}
+			first, second := points[0].Tag(test.tag), points[1].Tag(test.tag)
+			if len(first) != hashLength || len(second) != hashLength {
+				t.Fatalf("%s tags %q and %q are not hashed", test.tag, first, second)
+			}
+			if (first == second) != test.same {
+				t.Errorf("%s tags %q and %q, want equal=%t", test.tag, first, second, test.same)
+			}
+			if escaped := escapeTagValue(first); escaped != first {
+				t.Errorf("hashed %s tag %q escaped to %q", test.tag, first, escaped)
+			}
+		})
+	}
+}
+
+func TestBucketPointsRedactedTitle(t *testing.T) {
+	rules := []RedactionRule{{Field: "title", Pattern: `[\w.]+@[\w.]+`, Replacement: "email"}}
+	for i := range rules {
+		rules[i].regex = regexp.MustCompile(rules[i].Pattern)
+	}
+	categoryRules := []CategoryRule{{Name: "Email", Field: "title", Regex: "@"}, {Name: "Redacted", Field: "title", Regex: "email"}}
+	for i := range categoryRules {
+		categoryRules[i].regex = regexp.MustCompile(categoryRules[i].Regex)
+	}
+	config := Config{location: time.UTC, RedactionRules: rules, CategoryRules: categoryRules}
+	tests := []struct {
+		name       string
+		bucketType string
+		data       string
+		category   string
+		redactions int64
+	}{
+		{"window title", currentWindowType, `{"app":"Thunderbird","title":"Inbox - me@example.com"}`, "Redacted", 1},
+		{"tab title", webTabCurrentType, `{"url":"https://mail.example.com/","title":"me@example.com, you@example.com"}`, "Redacted", 2},
+		{"no match", currentWindowType, `{"app":"Thunderbird","title":"Inbox"}`, uncategorized, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summary := &Summary{}
+			points := bucketPoints(config, testBucket(test.bucketType), testEvents(test.data), summary)
+			if len(points) != 1 {
+				t.Fatalf("got %d points, want 1", len(points))
+			}
+			if category := points[0].Tag("category"); category != test.category {
+				t.Errorf("category = %q, want %q", category, test.category)
+			}
+			if redactions := summary.Redactions.Load(); redactions != test.redactions {
+				t.Errorf("redactions = %d, want %d", redactions, test.redactions)
+			}
+		})
+	}
+}
+
+func TestBucketPointsMalformedEvents(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/malformed_events.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	err = json.Unmarshal(fixture, &events)
+	if err != nil {
+		t.Fatalf("error unmarshalling the malformed events: %s", err)
+	}
+	if events[0].Duration != 0 {
+		t.Errorf("null duration decoded as %v, want 0", events[0].Duration)
+	}
+	config := Config{location: time.UTC}
+	tests := []struct {
+		bucketType string
+		want       int
+	}{
+		{currentWindowType, 0},
+		{webTabCurrentType, 5},
+		{appEditorType, 0},
+		{afkType, 0},
+		{stopwatchType, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.bucketType, func(t *testing.T) {
+			points := bucketPoints(config, testBucket(test.bucketType), events, &Summary{})
+			if len(points) != test.want {
+				t.Fatalf("got %d points, want %d", len(points), test.want)
+			}
+			for _, point := range points {
+				line := point.LineProtocol(time.Second)
+				if strings.Contains(line, "= ") || strings.Contains(line, "=,") {
+					t.Errorf("line with an empty tag value: %s", line)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketPointsEmptyRequiredTags(t *testing.T) {
+	tests := []struct {
+		name       string
+		bucketType string
+		data       string
+		want       int
+	}{
+		{"app", currentWindowType, `{"app":"Code","title":"main.go"}`, 1},
+		{"nul app", currentWindowType, `{"app":"\u0000","title":"main.go"}`, 0},
+		{"nul in the app", currentWindowType, `{"app":"Co\u0000de","title":"main.go"}`, 1},
+		{"control characters app", currentWindowType, `{"app":"\u001b\u0007","title":"main.go"}`, 0},
+		{"nul file", appEditorType, `{"file":"\u0000","project":"exporter"}`, 0},
+		{"control characters file", appEditorType, `{"file":"\u0000\u001b","project":"exporter"}`, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points := bucketPoints(Config{location: time.UTC}, testBucket(test.bucketType), testEvents(test.data), &Summary{})
+			if len(points) != test.want {
+				t.Fatalf("got %d points, want %d", len(points), test.want)
+			}
+			for _, point := range points {
+				line := point.LineProtocol(time.Second)
+				if _, _, _, err := parseTestLine(line); err != nil || strings.Contains(line, "= ") || strings.Contains(line, "=,") {
+					t.Errorf("line %q with an empty tag value", line)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketPointsEmptySanitizedTags(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{"nul project", `{"file":"main.go","project":"\u0000","language":"go"}`, map[string]string{"file": "main.go", "language": "go"}},
+		{"control characters language", `{"file":"main.go","project":"exporter","language":"\u001b\u007f"}`, map[string]string{"file": "main.go", "project": "exporter"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points := bucketPoints(Config{location: time.UTC}, testBucket(appEditorType), testEvents(test.data), &Summary{})
+			if len(points) != 1 {
+				t.Fatalf("got %d points, want 1", len(points))
+			}
+			line := points[0].LineProtocol(time.Second)
+			_, tags, _, err := parseTestLine(line)
+			if err != nil {
+				t.Fatalf("line %q doesn't parse: %s", line, err)
+			}
+			delete(tags, "client")
+			delete(tags, "hostname")
+			if fmt.Sprint(tags) != fmt.Sprint(test.want) {
+				t.Errorf("line %q has the tags %v, want %v", line, tags, test.want)
+			}
+		})
+	}
+}
+
+func TestBucketPointsEndTimestamp(t *testing.T) {
+	events := []Event{
+		{ID: 1, Timestamp: testTime.Add(100 * time.Millisecond), Duration: 1.6, Data: json.RawMessage(`{"status":"not-afk"}`)},
+		{ID: 2, Timestamp: testTime.Add(300 * time.Millisecond), Duration: 1.6, Data: json.RawMessage(`{"status":"not-afk"}`)},
+	}
+	tests := []struct {
+		precision string
+		ends      []int64
+		distinct  bool
+	}{
+		{"s", []int64{1741944414, 1741944414}, false},
+		{"ms", []int64{1741944414700, 1741944414900}, true},
+		{"us", []int64{1741944414700000, 1741944414900000}, true},
+		{"ns", []int64{1741944414700000000, 1741944414900000000}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.precision, func(t *testing.T) {
+			config := Config{Backend: backendInfluxDB, location: time.UTC, IncludeEndTimestamp: true, TimestampAt: timestampAtEnd, precision: precisions[test.precision]}
+			points := bucketPoints(config, testBucket(afkType), events, &Summary{})
+			if len(points) != 2 {
+				t.Fatalf("got %d points, want 2", len(points))
+			}
+			var lines []string
+			for i, point := range points {
+				var end any
+				for _, field := range point.Fields {
+					if field.Key == "end" {
+						end = field.Value
+					}
+				}
+				if end != test.ends[i] {
+					t.Errorf("point %d end = %v, want %d", i, end, test.ends[i])
+				}
+				line := strings.TrimSuffix(point.LineProtocol(config.precision), "\n")
+				if !strings.HasSuffix(line, fmt.Sprintf("end=%di %d", test.ends[i], test.ends[i])) {
+					t.Errorf("point %d = %q, want the end field and the timestamp to be %d", i, line, test.ends[i])
+				}
+				lines = append(lines, line[strings.LastIndex(line, " ")+1:])
+			}
+			if distinct := lines[0] != lines[1]; distinct != test.distinct {
+				t.Errorf("timestamps %q, want distinct=%t", lines, test.distinct)
+			}
+		})
+	}
+}
+
+func TestBucketPointsEndTimestampBackends(t *testing.T) {
+	events := []Event{{ID: 1, Timestamp: testTime.Add(100 * time.Millisecond), Duration: 1.6, Data: json.RawMessage(`{"status":"not-afk"}`)}}
+	tests := []struct {
+		backend string
+		format  string
+		end     int64
+	}{
+		{backendInfluxDB, "", 1741944414},
+		{backendInfluxDB, formatLineProtocol, 1741944414},
+		{backendInfluxDB, formatJSONL, 1741944414700000000},
+		{backendGraphite, "", 1741944414},
+		{backendRemoteWrite, "", 1741944414700},
+		{backendPostgres, "", 1741944414700000},
+		{backendQuestDB, "", 1741944414700000000},
+	}
+	for _, test := range tests {
+		t.Run(test.backend+" "+test.format, func(t *testing.T) {
+			config := Config{Backend: test.backend, Format: test.format, location: time.UTC, IncludeEndTimestamp: true, precision: time.Second}
+			points := bucketPoints(config, testBucket(afkType), events, &Summary{})
+			if len(points) != 1 {
+				t.Fatalf("got %d points, want 1", len(points))
+			}
+			var end any
+			for _, field := range points[0].Fields {
+				if field.Key == "end" {
+					end = field.Value
+				}
+			}
+			if end != test.end {
+				t.Errorf("end = %v, want %d", end, test.end)
+			}
+		})
+	}
+}
+
+func TestBucketPointsHostileClient(t *testing.T) {
+	tests := []struct {
+		client string
+		want   string
+	}{
+		{"aw-watcher-window", "aw-watcher-window"},
+		{"my watcher, v2", "my watcher, v2"},
+		{"client=evil,hostname=other", "client=evil,hostname=other"},
+		{`C:\watchers\`, `C:\watchers\`},
+		{"new\nline\ttab", "new line tab"},
+		{"nul\x00 and \x1b[31mcolor", "nul and [31mcolor"},
+		{"日本 watcher", "日本 watcher"},
+		{"evil 1741944413\nfake,hostname=x duration=1", "evil 1741944413 fake,hostname=x duration=1"},
+	}
+	for _, test := range tests {
+		t.Run(test.client, func(t *testing.T) {
+			entry := testBucket(currentWindowType)
+			entry.Client = test.client
+			points := bucketPoints(Config{location: time.UTC}, entry, testEvents(`{"app":"Code","title":"main.go"}`), &Summary{})
+			if len(points) != 1 {
+				t.Fatalf("got %d points, want 1", len(points))
+			}
+			line := points[0].LineProtocol(time.Second)
+			measurement, tags, _, err := parseTestLine(line)
+			if err != nil {
+				t.Fatalf("line %q doesn't parse: %s", line, err)
+			}
+			if measurement != currentWindowType || tags["client"] != test.want || tags["hostname"] != "laptop" || tags["app"] != "Code" {
+				t.Errorf("line %q parses to measurement=%q tags=%v, want client=%q", line, measurement, tags, test.want)
+			}
+		})
+	}
+}
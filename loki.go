@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+var lokiLabelTags = []string{"hostname", "client"}
+
+type LokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type LokiPush struct {
+	Streams []*LokiStream `json:"streams"`
+}
+
+func lokiLabels(point Point) map[string]string {
+	labels := map[string]string{"type": point.Measurement}
+	for _, key := range lokiLabelTags {
+		if value := point.Tag(key); value != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+func lokiStreamKey(point Point) string {
+	return point.Measurement + "\xff" + point.Tag("hostname") + "\xff" + point.Tag("client")
+}
+
+// lokiEventData returns the data of the event with the redaction rules and the hashing of the
+// tags applied to the same keys, so the log line doesn't expose what the tags hide
+func lokiEventData(config Config, data json.RawMessage) map[string]any {
+	values := make(map[string]any)
+	if json.Unmarshal(data, &values) != nil {
+		return nil
+	}
+	var redactions atomic.Int64
+	for _, field := range redactableFields {
+		if value, ok := values[field].(string); ok {
+			values[field] = protectValue(config, field, redactValue(config.RedactionRules, field, value, &redactions))
+		}
+	}
+	return values
+}
+
+func lokiLine(point Point) ([]byte, error) {
+	line := make(map[string]any)
+	for _, tag := range point.Tags {
+		if !slices.Contains(lokiLabelTags, tag.Key) {
+			line[tag.Key] = tag.Value
+		}
+	}
+	for _, field := range point.Fields {
+		line[field.Key] = field.Value
+	}
+	if point.Title != "" {
+		line["title"] = point.Title
+	}
+	if point.Data != nil {
+		line["data"] = point.Data
+	}
+	return json.Marshal(line)
+}
+
+func lokiPush(points []Point) (LokiPush, error) {
+	var push LokiPush
+	streams := make(map[string]*LokiStream)
+	for _, point := range points {
+		line, err := lokiLine(point)
+		if err != nil {
+			return push, err
+		}
+		key := lokiStreamKey(point)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &LokiStream{Stream: lokiLabels(point)}
+			streams[key] = stream
+			push.Streams = append(push.Streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(point.Time.UnixNano(), 10), string(line)})
+	}
+	return push, nil
+}
+
+func writeLoki(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	sorted := slices.Clone(points)
+	slices.SortStableFunc(sorted, func(a, b Point) int {
+		return cmp.Or(strings.Compare(lokiStreamKey(a), lokiStreamKey(b)), a.Time.Compare(b.Time))
+	})
+	var written int
+	for start := 0; start < len(sorted); start += config.BatchSize {
+		push, err := lokiPush(sorted[start:min(start+config.BatchSize, len(sorted))])
+		if err != nil {
+			return written, err
+		}
+		body, err := json.Marshal(push)
+		if err != nil {
+			return written, err
+		}
+		err = postLoki(ctx, client, config, body)
+		if err != nil {
+			return written, err
+		}
+		written += len(body)
+	}
+	return written, nil
+}
+
+func postLoki(ctx context.Context, client *http.Client, config Config, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", config.LokiUrl+lokiPushPath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if config.LokiTenantID != "" {
+		req.Header.Set("X-Scope-OrgID", config.LokiTenantID)
+	}
+	if config.LokiUsername != "" {
+		req.SetBasicAuth(config.LokiUsername, config.LokiPassword)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return fmt.Errorf("error reading data: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error sending data: %s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	return nil
+}
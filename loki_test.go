@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestLokiLine(t *testing.T) {
+	rules := []RedactionRule{{Field: "title", Pattern: `[\w.]+@[\w.]+`, Replacement: "email", regex: regexp.MustCompile(`[\w.]+@[\w.]+`)}}
+	tests := []struct {
+		name       string
+		config     Config
+		bucketType string
+		data       string
+		title      string
+		want       map[string]any
+	}{
+		{
+			"window", Config{}, currentWindowType,
+			`{"app":"Evince","title":"invoice.pdf"}`,
+			"invoice.pdf", map[string]any{"app": "Evince", "title": "invoice.pdf"},
+		},
+		{
+			"redacted title", Config{RedactionRules: rules}, currentWindowType,
+			`{"app":"Thunderbird","title":"me@example.org - Inbox"}`,
+			"email - Inbox", map[string]any{"app": "Thunderbird", "title": "email - Inbox"},
+		},
+		{
+			"hashed url and title", Config{HashSensitiveValues: true, HashSalt: "salt", HashedFields: []string{"url", "title"}}, webTabCurrentType,
+			`{"url":"https://github.com/me/secret","title":"secret","audible":false,"incognito":false,"tabCount":3}`,
+			protectValue(Config{HashSensitiveValues: true, HashSalt: "salt", HashedFields: []string{"title"}}, "title", "secret"),
+			map[string]any{
+				"url":       protectValue(Config{HashSensitiveValues: true, HashSalt: "salt", HashedFields: []string{"url"}}, "url", "https://github.com/me/secret"),
+				"title":     protectValue(Config{HashSensitiveValues: true, HashSalt: "salt", HashedFields: []string{"title"}}, "title", "secret"),
+				"audible":   false,
+				"incognito": false,
+				"tabCount":  3.0,
+			},
+		},
+		{
+			"stopwatch without title", Config{}, stopwatchType,
+			`{"label":"focus","running":true}`,
+			"", map[string]any{"label": "focus", "running": true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Backend = backendLoki
+			config.location = time.UTC
+			points := bucketPoints(config, testBucket(test.bucketType), testEvents(test.data), &Summary{})
+			if len(points) != 1 {
+				t.Fatalf("got %d points, want 1", len(points))
+			}
+			data, err := lokiLine(points[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var line map[string]any
+			err = json.Unmarshal(data, &line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			title, _ := line["title"].(string)
+			if title != test.title {
+				t.Errorf("title = %q, want %q", title, test.title)
+			}
+			if fmt.Sprint(line["data"]) != fmt.Sprint(test.want) {
+				t.Errorf("data = %v, want %v", line["data"], test.want)
+			}
+		})
+	}
+}
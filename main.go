@@ -2,20 +2,32 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
+	_ "time/tzdata"
+	"unicode"
+
+	"golang.org/x/net/publicsuffix"
 )
 
 type Bucket struct {
@@ -64,21 +76,249 @@ type StopWatch struct {
 	Running bool   `json:"running"`
 }
 
+type RedactionRule struct {
+	Field       string `json:"Field"`
+	Pattern     string `json:"Pattern"`
+	Replacement string `json:"Replacement"`
+	regex       *regexp.Regexp
+}
+
+type ServerCategory struct {
+	Name []string `json:"name"`
+	Rule struct {
+		Type       string `json:"type"`
+		Regex      string `json:"regex"`
+		IgnoreCase bool   `json:"ignore_case"`
+	} `json:"rule"`
+}
+
+type CategoryRule struct {
+	Name  string `json:"Name"`
+	Field string `json:"Field"`
+	Regex string `json:"Regex"`
+	regex *regexp.Regexp
+}
+
 type Config struct {
-	Bucket           string `json:"Bucket"`
-	InfluxDBHost     string `json:"InfluxDBHost"`
-	InfluxDBApiToken string `json:"InfluxDBApiToken"`
-	Org              string `json:"Org"`
-	ActivityWatchUrl string `json:"ActivityWatchUrl"`
+	Bucket                    string            `json:"Bucket"`
+	Backend                   string            `json:"Backend"`
+	InfluxDBHost              string            `json:"InfluxDBHost"`
+	InfluxDBApiToken          string            `json:"InfluxDBApiToken"`
+	InfluxDBVersion           int               `json:"InfluxDBVersion"`
+	InfluxDBUsername          string            `json:"InfluxDBUsername"`
+	InfluxDBPassword          string            `json:"InfluxDBPassword"`
+	WriteURL                  string            `json:"WriteURL"`
+	WriteSuccessStatus        []int             `json:"WriteSuccessStatus"`
+	DisableGzip               bool              `json:"DisableGzip"`
+	CompressionLevel          *int              `json:"CompressionLevel"`
+	UDPMaxDatagramSize        int               `json:"UDPMaxDatagramSize"`
+	SocketPath                string            `json:"SocketPath"`
+	SocketType                string            `json:"SocketType"`
+	Database                  string            `json:"Database"`
+	VictoriaMetricsUrl        string            `json:"VictoriaMetricsUrl"`
+	RemoteWriteUrl            string            `json:"RemoteWriteUrl"`
+	RemoteWriteUsername       string            `json:"RemoteWriteUsername"`
+	RemoteWritePassword       string            `json:"RemoteWritePassword"`
+	RemoteWriteHeaders        map[string]string `json:"RemoteWriteHeaders"`
+	PushgatewayUrl            string            `json:"PushgatewayUrl"`
+	QuestDBAddress            string            `json:"QuestDBAddress"`
+	QuestDBTLS                bool              `json:"QuestDBTLS"`
+	QuestDBKeyID              string            `json:"QuestDBKeyID"`
+	QuestDBPrivateKey         string            `json:"QuestDBPrivateKey"`
+	PostgresDSN               string            `json:"PostgresDSN"`
+	PostgresTable             string            `json:"PostgresTable"`
+	ClickHouseUrl             string            `json:"ClickHouseUrl"`
+	ClickHouseTable           string            `json:"ClickHouseTable"`
+	ClickHouseUser            string            `json:"ClickHouseUser"`
+	ClickHousePassword        string            `json:"ClickHousePassword"`
+	ClickHouseToken           string            `json:"ClickHouseToken"`
+	GraphiteAddress           string            `json:"GraphiteAddress"`
+	GraphitePrefix            string            `json:"GraphitePrefix"`
+	GraphiteFormat            string            `json:"GraphiteFormat"`
+	OtlpEndpoint              string            `json:"OtlpEndpoint"`
+	OtlpHeaders               map[string]string `json:"OtlpHeaders"`
+	LokiUrl                   string            `json:"LokiUrl"`
+	LokiTenantID              string            `json:"LokiTenantID"`
+	LokiUsername              string            `json:"LokiUsername"`
+	LokiPassword              string            `json:"LokiPassword"`
+	ElasticsearchUrl          string            `json:"ElasticsearchUrl"`
+	ElasticsearchIndex        string            `json:"ElasticsearchIndex"`
+	ElasticsearchApiKey       string            `json:"ElasticsearchApiKey"`
+	ElasticsearchUsername     string            `json:"ElasticsearchUsername"`
+	ElasticsearchPassword     string            `json:"ElasticsearchPassword"`
+	MqttBroker                string            `json:"MqttBroker"`
+	MqttClientID              string            `json:"MqttClientID"`
+	MqttUsername              string            `json:"MqttUsername"`
+	MqttPassword              string            `json:"MqttPassword"`
+	MqttCAFile                string            `json:"MqttCAFile"`
+	MqttInsecureSkipVerify    bool              `json:"MqttInsecureSkipVerify"`
+	MqttTopic                 string            `json:"MqttTopic"`
+	MqttQoS                   int               `json:"MqttQoS"`
+	MqttRetain                bool              `json:"MqttRetain"`
+	MqttMeasurements          []string          `json:"MqttMeasurements"`
+	KafkaBrokers              []string          `json:"KafkaBrokers"`
+	KafkaTopic                string            `json:"KafkaTopic"`
+	KafkaTLS                  bool              `json:"KafkaTLS"`
+	KafkaSASLMechanism        string            `json:"KafkaSASLMechanism"`
+	KafkaUsername             string            `json:"KafkaUsername"`
+	KafkaPassword             string            `json:"KafkaPassword"`
+	TimestreamDatabase        string            `json:"TimestreamDatabase"`
+	TimestreamTable           string            `json:"TimestreamTable"`
+	TimestreamRegion          string            `json:"TimestreamRegion"`
+	RedisAddress              string            `json:"RedisAddress"`
+	RedisUsername             string            `json:"RedisUsername"`
+	RedisPassword             string            `json:"RedisPassword"`
+	RedisDB                   int               `json:"RedisDB"`
+	RedisTLS                  bool              `json:"RedisTLS"`
+	RedisCAFile               string            `json:"RedisCAFile"`
+	RedisInsecureSkipVerify   bool              `json:"RedisInsecureSkipVerify"`
+	RedisRetention            string            `json:"RedisRetention"`
+	WebhookUrl                string            `json:"WebhookUrl"`
+	WebhookHeaders            map[string]string `json:"WebhookHeaders"`
+	WebhookMaxBytes           int               `json:"WebhookMaxBytes"`
+	GrafanaUrl                string            `json:"GrafanaUrl"`
+	GrafanaToken              string            `json:"GrafanaToken"`
+	GrafanaAnnotationTags     []string          `json:"GrafanaAnnotationTags"`
+	NotifyURL                 string            `json:"NotifyURL"`
+	NotifyAuthorization       string            `json:"NotifyAuthorization"`
+	NotifyFormat              string            `json:"NotifyFormat"`
+	NotifyTemplate            string            `json:"NotifyTemplate"`
+	NotifyRecovery            bool              `json:"NotifyRecovery"`
+	NotifyStateFile           string            `json:"NotifyStateFile"`
+	HomeAssistantUrl          string            `json:"HomeAssistantUrl"`
+	HomeAssistantToken        string            `json:"HomeAssistantToken"`
+	SpoolDir                  string            `json:"SpoolDir"`
+	SpoolMaxSizeMB            int64             `json:"SpoolMaxSizeMB"`
+	Format                    string            `json:"Format"`
+	Output                    string            `json:"Output"`
+	BatchSize                 int               `json:"BatchSize"`
+	StreamBatches             bool              `json:"StreamBatches"`
+	Org                       string            `json:"Org"`
+	OrgID                     string            `json:"OrgID"`
+	CreateBucket              bool              `json:"CreateBucket"`
+	SkipTokenCheck            bool              `json:"SkipTokenCheck"`
+	MaxBatchBytes             int               `json:"MaxBatchBytes"`
+	FieldValueLimit           int               `json:"FieldValueLimit"`
+	Precision                 string            `json:"Precision"`
+	RateLimitMaxWait          string            `json:"RateLimitMaxWait"`
+	BucketRetention           string            `json:"BucketRetention"`
+	ActivityWatchUrl          string            `json:"ActivityWatchUrl"`
+	SSHKeyFile                string            `json:"SSHKeyFile"`
+	SSHKnownHostsFile         string            `json:"SSHKnownHostsFile"`
+	SSHRemoteAddress          string            `json:"SSHRemoteAddress"`
+	DisableDomainTag          bool              `json:"DisableDomainTag"`
+	KeepWwwPrefix             bool              `json:"KeepWwwPrefix"`
+	ExcludeIncognito          bool              `json:"ExcludeIncognito"`
+	WebDomainAllowlist        []string          `json:"WebDomainAllowlist"`
+	WebDomainBlocklist        []string          `json:"WebDomainBlocklist"`
+	AppAllowlist              []string          `json:"AppAllowlist"`
+	AppBlocklist              []string          `json:"AppBlocklist"`
+	HashSensitiveValues       bool              `json:"HashSensitiveValues"`
+	HashSalt                  string            `json:"HashSalt"`
+	HashedFields              []string          `json:"HashedFields"`
+	RedactionRules            []RedactionRule   `json:"RedactionRules"`
+	HostnameAliases           map[string]string `json:"HostnameAliases"`
+	HostnameOverride          string            `json:"HostnameOverride"`
+	Devices                   map[string]string `json:"Devices"`
+	UnmappedDevices           string            `json:"UnmappedDevices"`
+	Dedup                     string            `json:"Dedup"`
+	ClientAliases             map[string]string `json:"ClientAliases"`
+	BrowserTag                bool              `json:"BrowserTag"`
+	IncludeBucketIDTag        bool              `json:"IncludeBucketIDTag"`
+	IncludeEventID            bool              `json:"IncludeEventID"`
+	ExportServerInfo          bool              `json:"ExportServerInfo"`
+	IncludeEndTimestamp       bool              `json:"IncludeEndTimestamp"`
+	TimestampAt               string            `json:"TimestampAt"`
+	DurationUnit              string            `json:"DurationUnit"`
+	SkipZeroDuration          bool              `json:"SkipZeroDuration"`
+	SkipZeroDurationStopwatch bool              `json:"SkipZeroDurationStopwatch"`
+	TimeBreakdownTags         bool              `json:"TimeBreakdownTags"`
+	Timezone                  string            `json:"Timezone"`
+	HostnameTimezones         map[string]string `json:"HostnameTimezones"`
+	LocalTimeField            bool              `json:"LocalTimeField"`
+	CategoryRules             []CategoryRule    `json:"CategoryRules"`
+	UseServerCategories       bool              `json:"UseServerCategories"`
+	CategorySeparator         string            `json:"CategorySeparator"`
+	SubcategoryTag            bool              `json:"SubcategoryTag"`
+	EditorFileDetail          string            `json:"EditorFileDetail"`
+	ProjectRoots              []string          `json:"ProjectRoots"`
+	QueryNonAfkWindows        bool              `json:"QueryNonAfkWindows"`
+	FilterAFK                 bool              `json:"FilterAFK"`
+	MergeWindow               string            `json:"MergeWindow"`
+	ChunkSize                 string            `json:"ChunkSize"`
+	PageSize                  int               `json:"PageSize"`
+	RequestsPerSecond         float64           `json:"RequestsPerSecond"`
+	GapThreshold              string            `json:"GapThreshold"`
+	SessionGap                string            `json:"SessionGap"`
+	Rollups                   []string          `json:"Rollups"`
+	SkipRawEvents             bool              `json:"SkipRawEvents"`
+	BucketListTimeout         string            `json:"BucketListTimeout"`
+	EventsTimeout             string            `json:"EventsTimeout"`
+	WriteTimeout              string            `json:"WriteTimeout"`
+	AttemptTimeout            string            `json:"AttemptTimeout"`
+	RetryWrites               bool              `json:"RetryWrites"`
+	MaxResponseSizeMB         int64             `json:"MaxResponseSizeMB"`
+	mergeWindow               time.Duration
+	chunkSize                 time.Duration
+	gapThreshold              time.Duration
+	sessionGap                time.Duration
+	bucketListTimeout         time.Duration
+	eventsTimeout             time.Duration
+	writeTimeout              time.Duration
+	attemptTimeout            time.Duration
+	maxResponseSize           int64
+	spoolMaxSize              int64
+	redisRetention            time.Duration
+	compressionLevel          int
+	bucketRetention           time.Duration
+	skipBadLines              bool
+	concurrency               int
+	failFast                  bool
+	rateLimitMaxWait          time.Duration
+	precision                 time.Duration
+	notifyTemplate            *template.Template
+	location                  *time.Location
+	hostnameLocations         map[string]*time.Location
+}
+
+type Summary struct {
+	ServerVersion     string
+	Events            atomic.Int64
+	ExcludedIncognito atomic.Int64
+	FilteredDomains   atomic.Int64
+	FilteredApps      atomic.Int64
+	Redactions        atomic.Int64
+	ZeroDuration      atomic.Int64
+	AfkDropped        atomic.Int64
+	AfkClipped        atomic.Int64
+	Merged            atomic.Int64
+	Deduplicated      atomic.Int64
+	SkippedBuckets    atomic.Int64
+	MissingBuckets    atomic.Int64
 }
 
 type retryableTransport struct {
 	transport             http.RoundTripper
 	TLSHandshakeTimeout   time.Duration
 	ResponseHeaderTimeout time.Duration
+	attemptTimeout        time.Duration
+	retryWrites           bool
+	activityWatchHost     string
+	webhookHost           string
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnCloseBody) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
 }
 
 const bucketsApiPath = "/api/0/buckets"
+const categoriesApiPath = "/api/0/settings/classes"
 const webTabCurrentType = "web.tab.current"
 const appEditorType = "app.editor.activity"
 const currentWindowType = "currentwindow"
@@ -86,6 +326,34 @@ const stopwatchType = "general.stopwatch"
 const afkType = "afkstatus"
 const retryCount = 3
 const stringLimit = 1024
+const hashLength = 12
+const uncategorized = "uncategorized"
+const timestampAtStart = "start"
+const timestampAtEnd = "end"
+const durationSeconds = "seconds"
+const durationMilliseconds = "milliseconds"
+const durationBoth = "both"
+const fileDetailFull = "full"
+const fileDetailBasename = "basename"
+const fileDetailRelative = "relative"
+const unmappedWarn = "warn"
+const unmappedFail = "fail"
+
+var defaultHashedFields = []string{"url", "domain", "file", "label", "title"}
+var redactableFields = []string{"url", "file", "label", "title", "app", "project"}
+var categoryFields = []string{"app", "title", "url", "project"}
+
+var lineBreakReplacer = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ")
+
+// backslashes before an escaped character or at the end of a tag value would escape the delimiter
+var escapingBackslashes = regexp.MustCompile(`\\+([,= ]|$)`)
+var tagValueEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var measurementBackslashes = regexp.MustCompile(`\\+([, ]|$)`)
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+var debug bool
+
+var errorLog = log.New(os.Stderr, "", log.LstdFlags)
 
 func shouldRetry(err error, resp *http.Response) bool {
 	if err != nil {
@@ -102,21 +370,45 @@ func shouldRetry(err error, resp *http.Response) bool {
 	}
 }
 
+// requestNotSent tells if the request failed before the connection was established,
+// when the server can't have received any of it
+func requestNotSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
 func (t *retryableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	var bodyBytes []byte
 	if req.Body != nil {
 		bodyBytes, _ = io.ReadAll(req.Body)
 		req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	}
-	resp, err := t.transport.RoundTrip(req)
+	resp, err := t.attempt(req)
+	// the queries sent to ActivityWatch only read and the webhook deliveries are always retried,
+	// other writes that reached the server may have been stored already
+	retryable := req.Method == "GET" || req.Method == "HEAD" || req.URL.Host == t.activityWatchHost || req.URL.Host == t.webhookHost || t.retryWrites
 	retries := 0
 	for shouldRetry(err, resp) && retries < retryCount {
+		if !retryable && !requestNotSent(err) {
+			debugf("Not retrying the %s request to %s, RetryWrites is disabled\n", req.Method, req.URL)
+			break
+		}
 		backoff := time.Duration(math.Pow(2, float64(retries))) * time.Second
-		time.Sleep(backoff)
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			debugf("Not retrying the request to %s, the backoff of %s would end after its deadline\n", req.URL, backoff)
+			break
+		}
 		if resp != nil && resp.Body != nil {
 			io.Copy(io.Discard, resp.Body)
 			resp.Body.Close()
 		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
 		if req.Body != nil {
 			req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 		}
@@ -124,31 +416,367 @@ func (t *retryableTransport) RoundTrip(req *http.Request) (*http.Response, error
 			log.Printf("Previous request failed with %s", resp.Status)
 		}
 		log.Printf("Retry %d of request to: %s", retries+1, req.URL)
-		resp, err = t.transport.RoundTrip(req)
+		resp, err = t.attempt(req)
 		retries++
 	}
 	return resp, err
 }
 
+func (t *retryableTransport) attempt(req *http.Request) (*http.Response, error) {
+	if t.attemptTimeout == 0 {
+		return t.transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.attemptTimeout)
+	resp, err := t.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
 func handleApiError(message string, err error, apiErrors *atomic.Int64) {
 	apiErrors.Add(1)
-	log.SetOutput(os.Stderr)
-	log.Println(message, err)
-	log.SetOutput(os.Stdout)
+	firstMessage := fmt.Sprint(message, " ", err)
+	firstApiError.CompareAndSwap(nil, &firstMessage)
+	errorLog.Println(message, err)
+}
+
+func parseDurationOption(name string, value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Fatalf("Invalid %s %q, must be a positive duration like %s\n", name, value, defaultValue)
+	}
+	return duration
+}
+
+func debugf(format string, v ...any) {
+	if debug {
+		log.Printf("DEBUG "+format, v...)
+	}
+}
+
+func logSummary(summary *Summary, apiErrors *atomic.Int64) {
+	log.Printf("Summary: server_version=%s events=%d excluded_incognito=%d filtered_domains=%d filtered_apps=%d redactions=%d zero_duration=%d afk_dropped=%d afk_clipped=%d merged=%d deduplicated=%d skipped_buckets=%d missing_buckets=%d errors=%d\n",
+		summary.ServerVersion,
+		summary.Events.Load(),
+		summary.ExcludedIncognito.Load(),
+		summary.FilteredDomains.Load(),
+		summary.FilteredApps.Load(),
+		summary.Redactions.Load(),
+		summary.ZeroDuration.Load(),
+		summary.AfkDropped.Load(),
+		summary.AfkClipped.Load(),
+		summary.Merged.Load(),
+		summary.Deduplicated.Load(),
+		summary.SkippedBuckets.Load(),
+		summary.MissingBuckets.Load(),
+		apiErrors.Load(),
+	)
+}
+
+func redactValue(rules []RedactionRule, field string, value string, redactions *atomic.Int64) string {
+	for _, rule := range rules {
+		if rule.Field != field {
+			continue
+		}
+		matches := rule.regex.FindAllStringIndex(value, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		redactions.Add(int64(len(matches)))
+		value = rule.regex.ReplaceAllString(value, rule.Replacement)
+	}
+	return value
+}
+
+func categorize(rules []CategoryRule, values map[string]string) string {
+	for _, rule := range rules {
+		if value, ok := values[rule.Field]; ok && rule.regex.MatchString(value) {
+			return rule.Name
+		}
+	}
+	return uncategorized
+}
+
+func categoryTags(category string, separator string, subcategoryTag bool) []Tag {
+	if !subcategoryTag {
+		return []Tag{{Key: "category", Value: category}}
+	}
+	category, subcategory, _ := strings.Cut(category, separator)
+	return []Tag{{Key: "category", Value: category}, {Key: "subcategory", Value: subcategory}}
+}
+
+func fetchServerCategories(ctx context.Context, client *http.Client, config Config) ([]CategoryRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.bucketListTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", activityWatchApiUrl(config, categoriesApiPath), nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, apiErrorMessage(body))
+	}
+	var categories []ServerCategory
+	err = json.Unmarshal(body, &categories)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(categories, func(a, b ServerCategory) int {
+		return len(b.Name) - len(a.Name)
+	})
+	var rules []CategoryRule
+	for _, category := range categories {
+		if category.Rule.Type != "regex" || category.Rule.Regex == "" || len(category.Name) == 0 {
+			continue
+		}
+		pattern := category.Rule.Regex
+		if category.Rule.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Skipping server category %s with invalid regex %q: %s\n", strings.Join(category.Name, config.CategorySeparator), pattern, err)
+			continue
+		}
+		name := strings.Join(category.Name, config.CategorySeparator)
+		for _, field := range []string{"app", "title"} {
+			rules = append(rules, CategoryRule{Name: name, Field: field, Regex: pattern, regex: regex})
+		}
+	}
+	return rules, nil
+}
+
+func protectValue(config Config, field string, value string) string {
+	if !config.HashSensitiveValues || value == "" || !slices.Contains(config.HashedFields, field) {
+		return value
+	}
+	sum := sha256.Sum256([]byte(config.HashSalt + value))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+func hostnameLocation(config Config, hostname string) *time.Location {
+	if location, ok := config.hostnameLocations[hostname]; ok {
+		return location
+	}
+	return config.location
+}
+
+func eventEnd(event Event) time.Time {
+	return event.Timestamp.Add(time.Duration(event.Duration * float64(time.Second)))
+}
+
+// sanitizeString turns line breaks and tabs into spaces and drops invalid UTF-8
+// and the remaining C0 and C1 control characters, like NUL, BEL or ESC
+func sanitizeString(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, lineBreakReplacer.Replace(strings.ToValidUTF8(value, "")))
+}
+
+func doubleBackslashes(match string) string {
+	return strings.TrimRight(match, ",= ") + match
+}
+
+func escapeMeasurement(measurement string) string {
+	measurement = measurementBackslashes.ReplaceAllStringFunc(sanitizeString(measurement), doubleBackslashes)
+	return measurementEscaper.Replace(measurement)
 }
 
 func escapeTagValue(value string) string {
-	withoutCommas := strings.ReplaceAll(value, ",", `\,`)
-	withoutEquals := strings.ReplaceAll(withoutCommas, "=", `\=`)
-	escaped := strings.ReplaceAll(withoutEquals, ` `, `\ `)
-	runes := []rune(escaped)
-	if len(runes) <= stringLimit {
-		return escaped
+	runes := []rune(sanitizeString(value))
+	if len(runes) > stringLimit {
+		runes = append(runes[:stringLimit-3], []rune("...")...)
+	}
+	value = escapingBackslashes.ReplaceAllStringFunc(string(runes), doubleBackslashes)
+	return tagValueEscaper.Replace(value)
+}
+
+func fileBasename(file string) string {
+	return file[strings.LastIndexAny(file, `/\`)+1:]
+}
+
+func editorFile(file string, detail string, projectRoots []string) string {
+	switch detail {
+	case fileDetailBasename:
+		return fileBasename(file)
+	case fileDetailRelative:
+		relative := ""
+		for _, root := range projectRoots {
+			root = strings.TrimRight(root, `/\`)
+			rest, ok := strings.CutPrefix(file, root)
+			if !ok || rest == "" || !strings.ContainsAny(rest[:1], `/\`) {
+				continue
+			}
+			if relative == "" || len(rest) < len(relative) {
+				relative = rest[1:]
+			}
+		}
+		if relative == "" {
+			return fileBasename(file)
+		}
+		return relative
+	default:
+		return file
+	}
+}
+
+func normalizeHost(u *url.URL, keepWww bool) string {
+	host := strings.ToLower(u.Hostname())
+	if !keepWww {
+		host = strings.TrimPrefix(host, "www.")
+	}
+	port := u.Port()
+	if port == "" || port == "80" || port == "443" {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDomainExported(host string, allowlist []string, blocklist []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if matchesDomain(host, blocklist) {
+		return false
 	}
-	return string(runes[0:stringLimit-3]) + "..."
+	return len(allowlist) == 0 || matchesDomain(host, allowlist)
+}
+
+func matchesApp(app string, patterns []string) bool {
+	app = strings.ToLower(app)
+	for _, pattern := range patterns {
+		matched, _ := path.Match(strings.ToLower(pattern), app)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isAppExported(app string, allowlist []string, blocklist []string) bool {
+	if matchesApp(app, blocklist) {
+		return false
+	}
+	return len(allowlist) == 0 || matchesApp(app, allowlist)
+}
+
+func resolveHostname(config Config, hostname string) string {
+	if config.HostnameOverride != "" {
+		return config.HostnameOverride
+	}
+	if alias, ok := config.HostnameAliases[hostname]; ok {
+		return alias
+	}
+	return hostname
+}
+
+func resolveDevice(config Config, hostname string) string {
+	if device, ok := config.Devices[hostname]; ok {
+		return device
+	}
+	return resolveHostname(config, hostname)
+}
+
+func hostTags(config Config, hostname string) []Tag {
+	tags := []Tag{{Key: "hostname", Value: resolveHostname(config, hostname)}}
+	if len(config.Devices) > 0 {
+		tags = append(tags, Tag{Key: "device", Value: resolveDevice(config, hostname)})
+	}
+	return tags
+}
+
+func resolveClient(config Config, client string) string {
+	if alias, ok := config.ClientAliases[client]; ok {
+		return alias
+	}
+	return client
+}
+
+func browserName(client string, bucketID string) string {
+	if browser, ok := strings.CutPrefix(client, "aw-client-web-"); ok && browser != "" {
+		return browser
+	}
+	if browser, ok := strings.CutPrefix(bucketID, "aw-watcher-web-"); ok {
+		browser, _, _ = strings.Cut(browser, "_")
+		return browser
+	}
+	return ""
+}
+
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
 }
 
 func main() {
+	runStart := time.Now()
+	var days int
+	flag.IntVar(&days, "days", 1, "Number of days in the past to fetch")
+	var concurrency int
+	flag.IntVar(&concurrency, "concurrency", 4, "Maximum number of buckets fetched at the same time")
+	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
+	var discover bool
+	flag.BoolVar(&discover, "discover", false, "Discover the local ActivityWatch server when ActivityWatchUrl is not set")
+	var createSchema bool
+	flag.BoolVar(&createSchema, "init-schema", false, "Create the table or index template used by the configured backend and exit")
+	var backend string
+	flag.StringVar(&backend, "backend", "", "Backend to write to, overrides Backend from the config file")
+	var format string
+	flag.StringVar(&format, "format", "", "Write the points to a file in this format instead of a backend: csv, jsonl, parquet or line")
+	var stdout bool
+	flag.BoolVar(&stdout, "stdout", false, "Print the line protocol to stdout instead of writing it to InfluxDB, logging to stderr")
+	var dryRun bool
+	flag.BoolVar(&dryRun, "dry-run", false, "Fetch and convert the events without writing them to the backend")
+	var output string
+	flag.StringVar(&output, "output", "", "Output file of -format or of the sqlite backend, overrides Output from the config file")
+	var failOnEmpty bool
+	flag.BoolVar(&failOnEmpty, "fail-on-empty", false, "Exit with an error when there are no events to send")
+	var skipBadLines bool
+	flag.BoolVar(&skipBadLines, "skip-bad-lines", false, "Drop the lines rejected by InfluxDB and retry the write once")
+	var timeout time.Duration
+	flag.DurationVar(&timeout, "timeout", 0, "Maximum duration of the whole run, like 5m, cancelling the outstanding requests when reached")
+	var failFast bool
+	flag.BoolVar(&failFast, "fail-fast", false, "Stop fetching at the first bucket that fails and exit with an error without writing anything")
+	var flushOnTimeout bool
+	flag.BoolVar(&flushOnTimeout, "flush-on-timeout", false, "Write the points of the buckets fetched before the -timeout instead of exiting right away")
+	// the flag package exits with 2 on invalid flags, which is the code of the fetch failures
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	err := flag.CommandLine.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitConfig)
+	}
+
 	confFilePath := "activitywatch_exporter.json"
 	confData, err := os.Open(confFilePath)
 	if err != nil {
@@ -160,236 +788,510 @@ func main() {
 	if err != nil {
 		log.Fatalln("Error reading configuration: ", err)
 	}
-	if config.ActivityWatchUrl == "" {
-		log.Fatalln("ActivityWatchUrl is required")
+	if backend != "" {
+		config.Backend = backend
+	}
+	if format != "" {
+		config.Format = format
+	}
+	if output != "" {
+		config.Output = output
+	}
+	config.skipBadLines = skipBadLines
+	config.concurrency = concurrency
+	config.failFast = failFast
+	if config.FieldValueLimit != 0 && config.FieldValueLimit < 4 {
+		log.Fatalf("Invalid FieldValueLimit %d, must be at least 4\n", config.FieldValueLimit)
+	}
+	if config.FieldValueLimit > 0 {
+		fieldValueLimit = config.FieldValueLimit
+	}
+	if stdout {
+		config.Format = formatLineProtocol
+		config.Output = ""
+	}
+	if config.Format == "" || (config.Output != "" && config.Output != "-") {
+		log.SetOutput(os.Stdout)
+	}
+	validateBackend(&config)
+	config.PushgatewayUrl = strings.TrimRight(config.PushgatewayUrl, "/")
+	validateNotify(&config)
+	config.GrafanaUrl = strings.TrimRight(config.GrafanaUrl, "/")
+	if config.GrafanaUrl != "" && config.GrafanaToken == "" {
+		log.Fatalln("GrafanaToken is required with GrafanaUrl")
+	}
+	config.HomeAssistantUrl = strings.TrimRight(config.HomeAssistantUrl, "/")
+	if config.HomeAssistantUrl != "" && config.HomeAssistantToken == "" {
+		log.Fatalln("HomeAssistantToken is required with HomeAssistantUrl")
+	}
+	if len(config.GrafanaAnnotationTags) == 0 {
+		config.GrafanaAnnotationTags = []string{"activitywatch", "stopwatch"}
+	}
+	if config.HashSensitiveValues && len(config.HashedFields) == 0 {
+		config.HashedFields = defaultHashedFields
+	}
+	for _, field := range config.HashedFields {
+		if !slices.Contains(defaultHashedFields, field) {
+			log.Fatalf("Invalid field %q in HashedFields, must be one of %s\n", field, strings.Join(defaultHashedFields, ", "))
+		}
 	}
-	if config.Bucket == "" {
-		log.Fatalln("Bucket is required")
+	if config.TimestampAt == "" {
+		config.TimestampAt = timestampAtStart
 	}
-	if config.InfluxDBHost == "" {
-		log.Fatalln("InfluxDBHost is required")
+	if config.TimestampAt != timestampAtStart && config.TimestampAt != timestampAtEnd {
+		log.Fatalf("Invalid TimestampAt %q, must be %q or %q\n", config.TimestampAt, timestampAtStart, timestampAtEnd)
 	}
-	if config.InfluxDBApiToken == "" {
-		log.Fatalln("InfluxDBApiToken is required")
+	if config.DurationUnit == "" {
+		config.DurationUnit = durationSeconds
 	}
-	if config.Org == "" {
-		log.Fatalln("Org is required")
+	if !slices.Contains([]string{durationSeconds, durationMilliseconds, durationBoth}, config.DurationUnit) {
+		log.Fatalf("Invalid DurationUnit %q, must be %q, %q or %q\n", config.DurationUnit, durationSeconds, durationMilliseconds, durationBoth)
+	}
+	config.location, err = time.LoadLocation(config.Timezone)
+	if err != nil {
+		log.Fatalf("Invalid Timezone %q: %s\n", config.Timezone, err)
+	}
+	config.hostnameLocations = make(map[string]*time.Location)
+	for hostname, timezone := range config.HostnameTimezones {
+		config.hostnameLocations[hostname], err = time.LoadLocation(timezone)
+		if err != nil {
+			log.Fatalf("Invalid timezone %q for hostname %s: %s\n", timezone, hostname, err)
+		}
+	}
+	for i, rule := range config.RedactionRules {
+		if !slices.Contains(redactableFields, rule.Field) {
+			log.Fatalf("Invalid redaction rule field %q, must be one of: %s\n", rule.Field, strings.Join(redactableFields, ", "))
+		}
+		config.RedactionRules[i].regex, err = regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Fatalf("Invalid redaction rule pattern %q: %s\n", rule.Pattern, err)
+		}
+	}
+	if config.EditorFileDetail == "" {
+		config.EditorFileDetail = fileDetailFull
+	}
+	if !slices.Contains([]string{fileDetailFull, fileDetailBasename, fileDetailRelative}, config.EditorFileDetail) {
+		log.Fatalf("Invalid EditorFileDetail %q, must be %q, %q or %q\n", config.EditorFileDetail, fileDetailFull, fileDetailBasename, fileDetailRelative)
+	}
+	if config.UnmappedDevices == "" {
+		config.UnmappedDevices = unmappedWarn
+	}
+	if config.UnmappedDevices != unmappedWarn && config.UnmappedDevices != unmappedFail {
+		log.Fatalf("Invalid UnmappedDevices %q, must be %q or %q\n", config.UnmappedDevices, unmappedWarn, unmappedFail)
+	}
+	if config.Dedup != "" && config.Dedup != dedupLines && config.Dedup != dedupSeries {
+		log.Fatalf("Invalid Dedup %q, must be %q or %q\n", config.Dedup, dedupLines, dedupSeries)
+	}
+	if config.MergeWindow != "" {
+		config.mergeWindow, err = time.ParseDuration(config.MergeWindow)
+		if err != nil || config.mergeWindow < 0 {
+			log.Fatalf("Invalid MergeWindow %q, must be a positive duration like 5s\n", config.MergeWindow)
+		}
+	}
+	config.chunkSize = parseDurationOption("ChunkSize", config.ChunkSize, 24*time.Hour)
+	if config.GapThreshold != "" {
+		config.gapThreshold = parseDurationOption("GapThreshold", config.GapThreshold, 30*time.Minute)
+	}
+	for _, rollup := range config.Rollups {
+		if !slices.Contains(rollupNames, rollup) {
+			log.Fatalf("Invalid rollup %q in Rollups, must be one of %s\n", rollup, strings.Join(rollupNames, ", "))
+		}
+	}
+	if config.SkipRawEvents && len(config.Rollups) == 0 {
+		log.Fatalln("SkipRawEvents requires at least one rollup in Rollups")
+	}
+	if config.SessionGap != "" {
+		config.sessionGap = parseDurationOption("SessionGap", config.SessionGap, 10*time.Minute)
+	}
+	if config.StreamBatches && !influxHTTPWrite(config) && config.Format == "" {
+		log.Fatalln("StreamBatches requires the influxdb Backend without SocketPath or a udp WriteURL, or a Format")
+	}
+	if config.StreamBatches {
+		// these options need the events or the points of the whole export at once
+		for _, option := range []struct {
+			name string
+			set  bool
+		}{
+			{"GapThreshold", config.gapThreshold > 0},
+			{"SessionGap", config.sessionGap > 0},
+			{"MergeWindow", config.mergeWindow > 0},
+			{"FilterAFK", config.FilterAFK},
+			{"QueryNonAfkWindows", config.QueryNonAfkWindows},
+			{"Dedup", config.Dedup != ""},
+			{"GrafanaUrl", config.GrafanaUrl != ""},
+			{"HomeAssistantUrl", config.HomeAssistantUrl != ""},
+		} {
+			if option.set {
+				log.Fatalf("StreamBatches can't be used with %s\n", option.name)
+			}
+		}
+	}
+	config.bucketListTimeout = parseDurationOption("BucketListTimeout", config.BucketListTimeout, 30*time.Second)
+	config.eventsTimeout = parseDurationOption("EventsTimeout", config.EventsTimeout, 2*time.Minute)
+	config.writeTimeout = parseDurationOption("WriteTimeout", config.WriteTimeout, 2*time.Minute)
+	if config.AttemptTimeout != "" {
+		config.attemptTimeout = parseDurationOption("AttemptTimeout", config.AttemptTimeout, 30*time.Second)
+	}
+	config.rateLimitMaxWait = parseDurationOption("RateLimitMaxWait", config.RateLimitMaxWait, time.Minute)
+	if config.PageSize == 0 {
+		config.PageSize = 5000
+	}
+	if config.PageSize < 0 {
+		log.Fatalf("Invalid PageSize %d, must be a positive number\n", config.PageSize)
+	}
+	if config.MaxResponseSizeMB == 0 {
+		config.MaxResponseSizeMB = 256
+	}
+	if config.MaxResponseSizeMB < 0 {
+		log.Fatalf("Invalid MaxResponseSizeMB %d, must be a positive number\n", config.MaxResponseSizeMB)
+	}
+	config.maxResponseSize = config.MaxResponseSizeMB << 20
+	if config.SpoolMaxSizeMB == 0 {
+		config.SpoolMaxSizeMB = 100
+	}
+	if config.SpoolMaxSizeMB < 0 {
+		log.Fatalf("Invalid SpoolMaxSizeMB %d, must be a positive number\n", config.SpoolMaxSizeMB)
+	}
+	config.spoolMaxSize = config.SpoolMaxSizeMB << 20
+	if config.RequestsPerSecond < 0 {
+		log.Fatalf("Invalid RequestsPerSecond %g, must be 0 (unlimited) or a positive number\n", config.RequestsPerSecond)
+	}
+	if config.CategorySeparator == "" {
+		config.CategorySeparator = " > "
+	}
+	for i, rule := range config.CategoryRules {
+		if rule.Name == "" {
+			log.Fatalf("Category rule with regex %q is missing its name\n", rule.Regex)
+		}
+		if !slices.Contains(categoryFields, rule.Field) {
+			log.Fatalf("Invalid category rule field %q, must be one of: %s\n", rule.Field, strings.Join(categoryFields, ", "))
+		}
+		config.CategoryRules[i].regex, err = regexp.Compile(rule.Regex)
+		if err != nil {
+			log.Fatalf("Invalid category rule regex %q: %s\n", rule.Regex, err)
+		}
+	}
+	for _, pattern := range append(config.AppAllowlist, config.AppBlocklist...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid app pattern %q: %s\n", pattern, err)
+		}
 	}
 
-	var days int
-	flag.IntVar(&days, "days", 1, "Number of days in the past to fetch")
-	flag.Parse()
+	if concurrency < 1 {
+		log.Fatalln("concurrency must be at least 1")
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		runCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		context.AfterFunc(runCtx, func() {
+			if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+				runTimedOut.Store(true)
+				log.Printf("Run timed out after %s, cancelling the outstanding requests\n", timeout)
+			}
+		})
+		ctx = runCtx
+	}
+	if config.ActivityWatchUrl == discoverUrl || (config.ActivityWatchUrl == "" && discover) {
+		config.ActivityWatchUrl, err = discoverActivityWatchUrl(ctx)
+		if err != nil {
+			failRun(config, exitFetch, "discovering the ActivityWatch server", 0, fmt.Sprint("Error discovering the ActivityWatch server: ", err))
+		}
+		log.Printf("Using ActivityWatch server at %s\n", config.ActivityWatchUrl)
+	}
+	if config.ActivityWatchUrl == "" {
+		log.Fatalln("ActivityWatchUrl is required")
+	}
+	awUrl, err := parseActivityWatchUrl(config.ActivityWatchUrl)
+	if err != nil {
+		log.Fatalf("Invalid ActivityWatchUrl %q, %s\n", config.ActivityWatchUrl, err)
+	}
+	config.ActivityWatchUrl = awUrl.String()
 
+	httpTransport := &http.Transport{}
+	if awUrl.Scheme == sshScheme {
+		if config.SSHRemoteAddress == "" {
+			config.SSHRemoteAddress = "localhost:5600"
+		}
+		if _, _, err := net.SplitHostPort(config.SSHRemoteAddress); err != nil {
+			log.Fatalf("Invalid SSHRemoteAddress %q, must be a host and port like localhost:5600\n", config.SSHRemoteAddress)
+		}
+		tunnel, err := openSSHTunnel(config, awUrl)
+		if err != nil {
+			failRun(config, exitFetch, "opening the SSH tunnel", 0, fmt.Sprint("Error opening the SSH tunnel: ", err))
+		}
+		defer tunnel.Close()
+		awUrl = &url.URL{Scheme: "http", Host: config.SSHRemoteAddress, Path: awUrl.Path}
+		config.ActivityWatchUrl = awUrl.String()
+		httpTransport.DialContext = sshDialContext(tunnel, awUrl.Host)
+	}
+	var baseTransport http.RoundTripper = httpTransport
+	if config.RequestsPerSecond > 0 {
+		baseTransport = &rateLimitedTransport{
+			transport: baseTransport,
+			limiter:   newRateLimiter(config.RequestsPerSecond),
+			host:      awUrl.Host,
+		}
+	}
 	transport := &retryableTransport{
-		transport:             &http.Transport{},
+		transport:             baseTransport,
 		TLSHandshakeTimeout:   30 * time.Second,
 		ResponseHeaderTimeout: 30 * time.Second,
+		attemptTimeout:        config.attemptTimeout,
+		retryWrites:           config.RetryWrites,
+		activityWatchHost:     awUrl.Host,
+		webhookHost:           webhookHost(config),
 	}
 	client := &http.Client{
-		Timeout:   30 * time.Second,
 		Transport: transport,
 	}
+	if createSchema {
+		err = initSchema(ctx, client, config)
+		if err != nil {
+			failRun(config, exitWrite, "creating the schema", 0, fmt.Sprint("Error creating the schema: ", err))
+		}
+		log.Printf("Created the schema of the %s backend\n", config.Backend)
+		return
+	}
+	if influxHTTPWrite(config) && !config.SkipTokenCheck {
+		err = checkInfluxToken(ctx, client, config)
+		if err != nil {
+			failRun(config, exitWrite, "checking the InfluxDB token", 0, err.Error())
+		}
+	}
+	if influxHTTPWrite(config) && config.WriteURL == "" && config.InfluxDBVersion == 2 {
+		err = checkInfluxBucket(ctx, client, config, dryRun)
+		if err != nil {
+			failRun(config, exitWrite, "checking the InfluxDB bucket", 0, err.Error())
+		}
+	}
+	if spoolEnabled(config) && !dryRun {
+		replaySpool(ctx, client, config)
+	}
 
 	var apiErrors atomic.Int64
-	bucketsReq, _ := http.NewRequest("GET", config.ActivityWatchUrl+bucketsApiPath, nil)
+	var summary Summary
+	serverInfo, err := fetchServerInfo(ctx, client, config)
+	if err != nil {
+		failRun(config, exitFetch, "connecting to the ActivityWatch server", 0, fmt.Sprintf("ActivityWatch server unreachable at %s: %v", config.ActivityWatchUrl, err))
+	}
+	summary.ServerVersion = serverInfo.Version
+	log.Printf("Connected to ActivityWatch server flavor=%s version=%s hostname=%s\n", serverInfo.Flavor(), serverInfo.Version, serverInfo.Hostname)
+	bucketsCtx, cancelBuckets := context.WithTimeout(ctx, config.bucketListTimeout)
+	defer cancelBuckets()
+	bucketsReq, _ := http.NewRequestWithContext(bucketsCtx, "GET", activityWatchApiUrl(config, bucketsApiPath), nil)
 	bucketsResp, err := client.Do(bucketsReq)
 	if err != nil {
-		log.Fatalln("Error trying to get bucket list: ", err)
+		failRun(config, exitFetch, "fetching the bucket list", 0, fmt.Sprint("Error trying to get bucket list: ", err))
 	}
 	defer bucketsResp.Body.Close()
-	bucketsBody, err := io.ReadAll(bucketsResp.Body)
+	bucketsBody, err := readBody(bucketsResp.Body, config.maxResponseSize)
 	if err != nil {
-		log.Fatalln("Error reading bucket list data: ", err)
+		failRun(config, exitFetch, "fetching the bucket list", 0, fmt.Sprint("Error reading bucket list data: ", err))
 	}
 	if bucketsResp.StatusCode != http.StatusOK {
-		log.Fatalf("Error trying to get bucket list: %s\n", string(bucketsBody))
+		failRun(config, exitFetch, "fetching the bucket list", 0, fmt.Sprintf("Error trying to get bucket list: %s: %s", bucketsResp.Status, apiErrorMessage(bucketsBody)))
 	}
 
 	var bucketsList Buckets
 	err = json.Unmarshal(bucketsBody, &bucketsList)
 	if err != nil {
-		log.Fatalln("Error unmarshalling bucket list data: ", err)
+		failRun(config, exitFetch, "fetching the bucket list", 0, fmt.Sprint("Error unmarshalling bucket list data: ", err))
 	}
 
-	wg := &sync.WaitGroup{}
-	payload := bytes.Buffer{}
-	for _, entry := range bucketsList {
-		wg.Add(1)
-
-		go func(payload *bytes.Buffer, apiErrors *atomic.Int64) {
-			defer wg.Done()
+	if config.UseServerCategories {
+		serverRules, err := fetchServerCategories(ctx, client, config)
+		if err != nil {
+			log.Println("Warning: unable to get the categories from the ActivityWatch server settings, only the configured CategoryRules are used:", err)
+		} else {
+			debugf("Loaded %d category rules from the ActivityWatch server\n", len(serverRules))
+			config.CategoryRules = append(config.CategoryRules, serverRules...)
+		}
+	}
 
-			start := time.Now().AddDate(0, 0, -days).Format("2006-01-02T15:04:05.000000-07:00")
-			eventsUrl := fmt.Sprintf(config.ActivityWatchUrl+bucketsApiPath+"/%s/events?start=%s", entry.ID, url.QueryEscape(start))
-			eventsReq, _ := http.NewRequest("GET", eventsUrl, nil)
-			eventsResp, err := client.Do(eventsReq)
-			if err != nil {
-				handleApiError(fmt.Sprintf("Error trying to get events for bucket=%s: ", entry.ID), err, apiErrors)
-				return
+	if len(config.Devices) > 0 {
+		unmapped := make(map[string]bool)
+		for _, entry := range bucketsList {
+			if _, ok := config.Devices[entry.Hostname]; !ok && !unmapped[entry.Hostname] {
+				unmapped[entry.Hostname] = true
+				if config.UnmappedDevices == unmappedFail {
+					failRun(config, exitConfig, "mapping the devices", 0, fmt.Sprintf("No device configured in Devices for hostname=%s of bucket=%s", entry.Hostname, entry.ID))
+				}
+				log.Printf("Warning: no device configured in Devices for hostname=%s, using it as the device tag\n", entry.Hostname)
 			}
-			defer eventsResp.Body.Close()
-			eventsBody, err := io.ReadAll(eventsResp.Body)
-			if err != nil {
-				handleApiError(fmt.Sprintf("Error reading events data for bucket=%s: ", entry.ID), err, apiErrors)
-				return
+		}
+	}
+
+	endTime := time.Now()
+	startTime := endTime.AddDate(0, 0, -days)
+	rollupsStart := startTime
+	if len(config.Rollups) > 0 {
+		// fetch the events since midnight of the first day, so the rollups of every exported day are complete
+		for _, entry := range bucketsList {
+			midnight := startOfDay(rollupsStart, hostnameLocation(config, entry.Hostname))
+			if midnight.Before(startTime) {
+				startTime = midnight
 			}
-			if eventsResp.StatusCode != http.StatusOK {
-				handleApiError(fmt.Sprintf("Error trying to get events data for bucket=%s:\n", entry.ID), err, apiErrors)
-				return
+		}
+	}
+	// a hostname can have several afk watchers, sorted so the query doesn't change between runs
+	afkBuckets := make(map[string][]string)
+	for _, entry := range bucketsList {
+		if entry.Type == afkType {
+			afkBuckets[entry.Hostname] = append(afkBuckets[entry.Hostname], entry.ID)
+		}
+	}
+	for _, ids := range afkBuckets {
+		slices.Sort(ids)
+	}
+	if config.QueryNonAfkWindows {
+		for _, entry := range bucketsList {
+			if _, ok := afkBuckets[entry.Hostname]; entry.Type == currentWindowType && !ok {
+				log.Printf("Warning: no afk bucket found for hostname=%s, exporting all the events of bucket=%s\n", entry.Hostname, entry.ID)
 			}
-			var events []Event
-			err = json.Unmarshal(eventsBody, &events)
+		}
+	}
+
+	var entries []Bucket
+	for _, entry := range bucketsList {
+		if !entry.LastUpdated.IsZero() && entry.LastUpdated.Before(startTime) {
+			debugf("Skipping bucket=%s last updated at %s\n", entry.ID, entry.LastUpdated.Format(time.RFC3339))
+			summary.SkippedBuckets.Add(1)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	rollups := newRollups(config.Rollups, rollupsStart)
+	var extra []Point
+	if config.ExportServerInfo {
+		extra = append(extra, serverInfoPoint(config, serverInfo, endTime))
+	}
+	var points []Point
+	var pointCount, written int
+	bucketExported := make(map[string]int)
+	writeCtx := ctx
+	if config.StreamBatches {
+		if flushOnTimeout {
+			writeCtx = context.WithoutCancel(ctx)
+		}
+		var writer PointWriter
+		if !dryRun || config.Format != "" {
+			writer, err = newStreamWriter(client, config)
 			if err != nil {
-				handleApiError(fmt.Sprintf("Error unmarshalling events data for bucket=%s api response data: %s", entry.ID, string(eventsBody)), err, apiErrors)
-				return
+				failRun(config, exitWrite, "writing the points", apiErrors.Load(), err.Error())
 			}
-
-			for _, event := range events {
-				var influxLine string
-				switch entry.Type {
-				case webTabCurrentType:
-					data := new(WebTabCurrent)
-					err := json.Unmarshal(event.Data, data)
-					if err != nil {
-						log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
-						continue
-					}
-					u, err := url.Parse(data.URL)
-					if err != nil {
-						log.Printf("Error parsing URL=%s: %s\n", data.URL, err)
-						continue
-					}
-					var cleanUrl string
-					if u.Host == "" {
-						cleanUrl = ""
-
-					} else {
-						cleanUrl = fmt.Sprintf(",url=%s", u.Host)
-					}
-					influxLine = fmt.Sprintf("%s,client=%s,hostname=%s%s duration=%.3f,audible=%t,incognito=%t %v\n",
-						entry.Type,
-						entry.Client,
-						escapeTagValue(entry.Hostname),
-						cleanUrl,
-						event.Duration,
-						data.Audible,
-						data.Incognito,
-						event.Timestamp.Unix(),
-					)
-				case appEditorType:
-					data := new(AppEditorActivity)
-					err := json.Unmarshal(event.Data, data)
-					if err != nil {
-						log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
-						continue
-					}
-					influxLine = fmt.Sprintf("%s,client=%s,hostname=%s,project=%s,language=%s,file=%s duration=%.3f %v\n",
-						entry.Type,
-						entry.Client,
-						escapeTagValue(entry.Hostname),
-						escapeTagValue(data.Project),
-						escapeTagValue(data.Language),
-						escapeTagValue(data.File),
-						event.Duration,
-						event.Timestamp.Unix(),
-					)
-				case currentWindowType:
-					data := new(CurrentWindow)
-					err := json.Unmarshal(event.Data, data)
-					if err != nil {
-						log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
-						continue
-					}
-					influxLine = fmt.Sprintf("%s,client=%s,hostname=%s,app=%s duration=%.3f %v\n",
-						entry.Type,
-						entry.Client,
-						escapeTagValue(entry.Hostname),
-						escapeTagValue(data.App),
-						event.Duration,
-						event.Timestamp.Unix(),
-					)
-				case stopwatchType:
-					data := new(StopWatch)
-					err := json.Unmarshal(event.Data, data)
-					if err != nil {
-						log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
-						continue
-					}
-					var label string
-					if data.Label == "" {
-						label = ""
-
-					} else {
-						label = fmt.Sprintf(",label=%s", escapeTagValue(data.Label))
-					}
-					influxLine = fmt.Sprintf("%s,client=%s,hostname=%s%s duration=%.3f,running=%t %v\n",
-						entry.Type,
-						entry.Client,
-						escapeTagValue(entry.Hostname),
-						label,
-						event.Duration,
-						data.Running,
-						event.Timestamp.Unix(),
-					)
-				case afkType:
-					data := new(AfkStatus)
-					err := json.Unmarshal(event.Data, data)
-					if err != nil {
-						log.Printf("Error unmarshalling event data for bucket=%s data=%s: %s\n", entry.ID, event.Data, err)
-						continue
-					}
-					influxLine = fmt.Sprintf("%s,client=%s,hostname=%s duration=%.3f,status=\"%s\" %v\n",
-						entry.Type,
-						entry.Client,
-						escapeTagValue(entry.Hostname),
-						event.Duration,
-						data.Status,
-						event.Timestamp.Unix(),
-					)
-				default:
-					log.Printf("Skipping unknown event type: %s\n", entry.Type)
-					continue
-				}
-
-				payload.WriteString(influxLine)
+		}
+		var result StreamResult
+		result, err = streamBuckets(ctx, writeCtx, client, config, entries, Period{Start: startTime, End: endTime}, rollups, extra, &summary, &apiErrors, writer)
+		if errors.Is(err, errFetchAborted) {
+			failRun(config, exitFetch, "fetching the events", apiErrors.Load(), fmt.Sprintf("Stopped fetching after the first failed bucket, the batches written before were kept: %s", *firstApiError.Load()))
+		}
+		if runTimedOut.Load() && !flushOnTimeout {
+			failRun(config, exitTimeout, "fetching the events", apiErrors.Load(), "Run timed out before fetching all the events, the batches written before were kept")
+		}
+		pointCount, written, bucketExported = result.Points, result.Written, result.BucketExported
+	} else {
+		mu := &sync.Mutex{}
+		bucketEvents := make(map[string][]Event)
+		fetchErrors := apiErrors.Load()
+		err = fetchBuckets(ctx, client, config, entries, afkBuckets, Period{Start: startTime, End: endTime}, &summary, &apiErrors, func(entry Bucket, events []Event) {
+			mu.Lock()
+			bucketEvents[entry.ID] = events
+			mu.Unlock()
+		})
+		if errors.Is(err, errFetchAborted) {
+			failRun(config, exitFetch, "fetching the events", apiErrors.Load(), fmt.Sprintf("Stopped fetching after the first failed bucket, nothing was written: %s", *firstApiError.Load()))
+		}
+		if runTimedOut.Load() {
+			if !flushOnTimeout {
+				failRun(config, exitTimeout, "fetching the events", apiErrors.Load(), "Run timed out before fetching all the events, nothing was written")
 			}
+			log.Println("Writing the points of the buckets fetched before the timeout")
+			writeCtx = context.WithoutCancel(ctx)
+		}
+		// the measurements derived from an incomplete fetch would overwrite the complete ones of a previous run
+		complete := ctx.Err() == nil && apiErrors.Load() == fetchErrors
+		if !complete && (config.gapThreshold > 0 || config.sessionGap > 0 || len(config.Rollups) > 0) {
+			log.Println("Skipping the rollups, gaps and sessions of the incomplete fetch")
+		}
 
-		}(&payload, &apiErrors)
-
+		if config.gapThreshold > 0 && complete {
+			points = append(points, gapPoints(config, bucketsList, bucketEvents)...)
+		}
+		if config.sessionGap > 0 && complete {
+			points = append(points, sessionPoints(config, bucketsList, bucketEvents, startTime)...)
+		}
+		if config.FilterAFK {
+			filterAfkEvents(bucketsList, bucketEvents, &summary)
+		}
+		for _, entry := range bucketsList {
+			events, ok := bucketEvents[entry.ID]
+			if !ok {
+				continue
+			}
+			if complete {
+				rollups.AddBucket(config, entry, events)
+			}
+			if config.SkipRawEvents {
+				continue
+			}
+			if config.mergeWindow > 0 {
+				merged := mergeEvents(events, config.mergeWindow)
+				summary.Merged.Add(int64(len(events) - len(merged)))
+				events = merged
+			}
+			entryPoints := bucketPoints(config, entry, events, &summary)
+			bucketExported[entry.ID] = len(entryPoints)
+			points = append(points, entryPoints...)
+		}
+		points = append(points, rollups.Points(config)...)
+		points = append(points, extra...)
+		sortPoints(points)
+		if config.Dedup != "" {
+			var dropped int
+			points, dropped = dedupPoints(points, config.Dedup, writePrecision(config))
+			summary.Deduplicated.Add(int64(dropped))
+		}
+		pointCount = len(points)
 	}
+	logSummary(&summary, &apiErrors)
 
-	wg.Wait()
-
-	if len(payload.Bytes()) == 0 {
-		log.Fatalln("No data to send")
+	if pointCount == 0 && failOnEmpty {
+		err = errors.New("No data to send")
+	} else if pointCount == 0 {
+		log.Println("No data to send")
+	} else if dryRun && config.Format == "" {
+		log.Printf("Dry run, skipping writing %d points to the %s backend\n", pointCount, config.Backend)
+	} else if !config.StreamBatches {
+		written, err = writePoints(writeCtx, client, config, points)
 	}
-	var buf bytes.Buffer
-	w := gzip.NewWriter(&buf)
-	w.Write(payload.Bytes())
-	err = w.Close()
-	if err != nil {
-		log.Fatalln("Error compressing data: ", err)
-	}
-	url := fmt.Sprintf("https://%s/api/v2/write?precision=s&org=%s&bucket=%s", config.InfluxDBHost, config.Org, config.Bucket)
-	post, _ := http.NewRequest("POST", url, &buf)
-	post.Header.Set("Accept", "application/json")
-	post.Header.Set("Authorization", "Token "+config.InfluxDBApiToken)
-	post.Header.Set("Content-Encoding", "gzip")
-	post.Header.Set("Content-Type", "text/plain; charset=utf-8")
-	resp, err := client.Do(post)
-	if err != nil {
-		log.Fatalln("Error sending data: ", err)
+	if config.GrafanaUrl != "" && !dryRun {
+		createGrafanaAnnotations(ctx, client, config, points)
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalln("Error reading data: ", err)
+	if config.HomeAssistantUrl != "" && !dryRun {
+		pushHomeAssistantStates(ctx, client, config, points)
 	}
-	if resp.StatusCode != 204 {
-		log.Fatal("Error sending data: ", string(body))
+	if config.PushgatewayUrl != "" && !dryRun {
+		pushRunMetrics(ctx, client, config, RunMetrics{
+			BucketEvents: bucketExported,
+			BytesWritten: written,
+			Duration:     time.Since(runStart),
+			Errors:       apiErrors.Load(),
+			Success:      err == nil && apiErrors.Load() == 0,
+		})
+	}
+	if err != nil && pointCount == 0 {
+		failRun(config, exitFetch, "fetching the events", apiErrors.Load(), err.Error())
+	}
+	if err != nil {
+		failRun(config, exitWrite, "writing the points", apiErrors.Load(), err.Error())
 	}
 
+	if apiErrors.Load() > 0 && pointCount == 0 {
+		failRun(config, exitFetch, "fetching the events", apiErrors.Load(), fmt.Sprintf("Errors: %d", apiErrors.Load()))
+	}
 	if apiErrors.Load() > 0 {
-		log.Fatalf("Errors: %d\n", apiErrors.Load())
+		failRun(config, exitPartial, "fetching the events", apiErrors.Load(), fmt.Sprintf("Errors: %d", apiErrors.Load()))
 	}
+	notifySuccess(config)
 }
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawUrl  string
+		keepWww bool
+		want    string
+	}{
+		{"lowercase", "https://GitHub.COM/rare-magma", false, "github.com"},
+		{"www stripped", "https://www.github.com/", false, "github.com"},
+		{"www kept", "https://www.github.com/", true, "www.github.com"},
+		{"only leading www", "https://docs.www.example.org/", false, "docs.www.example.org"},
+		{"default https port", "https://github.com:443/", false, "github.com"},
+		{"default http port", "http://github.com:80/", false, "github.com"},
+		{"non-default port", "http://localhost:5600/", false, "localhost:5600"},
+		{"non-default port with www", "https://www.example.org:8443/", false, "example.org:8443"},
+		{"ipv6 with port", "http://[::1]:8080/", false, "[::1]:8080"},
+		{"ipv6 default port", "http://[::1]:80/", false, "::1"},
+		{"idn", "https://www.Bücher.example/", false, "bücher.example"},
+		{"idn with port", "https://bücher.example:8080/", false, "bücher.example:8080"},
+		{"punycode", "https://www.xn--bcher-kva.example/", false, "xn--bcher-kva.example"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, err := url.Parse(test.rawUrl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := normalizeHost(u, test.keepWww); got != test.want {
+				t.Errorf("normalizeHost(%q, %t) = %q, want %q", test.rawUrl, test.keepWww, got, test.want)
+			}
+		})
+	}
+}
+
+func TestProtectValue(t *testing.T) {
+	config := Config{HashSensitiveValues: true, HashSalt: "salt", HashedFields: defaultHashedFields}
+	tests := []struct {
+		name   string
+		config Config
+		field  string
+		value  string
+		other  Config
+		same   bool
+	}{
+		{"same salt", config, "url", "github.com", config, true},
+		{"other salt", config, "url", "github.com", Config{HashSensitiveValues: true, HashSalt: "pepper", HashedFields: defaultHashedFields}, false},
+		{"no salt", Config{HashSensitiveValues: true, HashedFields: defaultHashedFields}, "file", "/home/user/main.go", config, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hashed := protectValue(test.config, test.field, test.value)
+			if len(hashed) != hashLength || hashed == test.value {
+				t.Fatalf("protectValue(%q) = %q, want a %d characters hash", test.value, hashed, hashLength)
+			}
+			if again := protectValue(test.config, test.field, test.value); again != hashed {
+				t.Errorf("protectValue(%q) = %q then %q, want stable hashes", test.value, hashed, again)
+			}
+			if other := protectValue(test.other, test.field, test.value); (other == hashed) != test.same {
+				t.Errorf("protectValue(%q) = %q and %q with salts %q and %q, want equal=%t", test.value, hashed, other, test.config.HashSalt, test.other.HashSalt, test.same)
+			}
+		})
+	}
+}
+
+func TestProtectValueUnchanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		field  string
+		value  string
+	}{
+		{"disabled", Config{HashSalt: "salt", HashedFields: defaultHashedFields}, "url", "github.com"},
+		{"field not hashed", Config{HashSensitiveValues: true, HashSalt: "salt", HashedFields: []string{"file"}}, "url", "github.com"},
+		{"empty value", Config{HashSensitiveValues: true, HashSalt: "salt", HashedFields: defaultHashedFields}, "url", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := protectValue(test.config, test.field, test.value); got != test.value {
+				t.Errorf("protectValue(%q, %q) = %q, want it unchanged", test.field, test.value, got)
+			}
+		})
+	}
+}
+
+func TestCategorize(t *testing.T) {
+	var rules []CategoryRule
+	for _, rule := range []CategoryRule{
+		{Name: "Work>Code", Field: "app", Regex: "(?i)code|vim"},
+		{Name: "Comms", Field: "app", Regex: "(?i)slack"},
+		{Name: "Work>Review", Field: "url", Regex: `^github\.com$`},
+		{Name: "Media", Field: "title", Regex: "YouTube"},
+		{Name: "Work>Exporter", Field: "project", Regex: "exporter"},
+		{Name: "Comms", Field: "title", Regex: "(?i)mail"},
+	} {
+		rule.regex = regexp.MustCompile(rule.Regex)
+		rules = append(rules, rule)
+	}
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   string
+	}{
+		{"app", map[string]string{"app": "Code", "title": "main.go"}, "Work>Code"},
+		{"first rule wins", map[string]string{"app": "Slack", "title": "YouTube"}, "Comms"},
+		{"earlier field rule wins", map[string]string{"app": "vim", "title": "YouTube"}, "Work>Code"},
+		{"url host", map[string]string{"url": "github.com", "title": "YouTube"}, "Work>Review"},
+		{"url host anchored", map[string]string{"url": "gist.github.com"}, uncategorized},
+		{"title", map[string]string{"app": "Firefox", "title": "Cats - YouTube"}, "Media"},
+		{"project", map[string]string{"project": "activitywatch-exporter"}, "Work>Exporter"},
+		{"later rule", map[string]string{"app": "Thunderbird", "title": "Inbox - Mail"}, "Comms"},
+		{"missing field", map[string]string{"project": "dotfiles"}, uncategorized},
+		{"no values", map[string]string{}, uncategorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := categorize(rules, test.values); got != test.want {
+				t.Errorf("categorize(%v) = %q, want %q", test.values, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCategoryTags(t *testing.T) {
+	tests := []struct {
+		name           string
+		category       string
+		subcategoryTag bool
+		want           []Tag
+	}{
+		{"single tag", "Work>Code", false, []Tag{{Key: "category", Value: "Work>Code"}}},
+		{"subcategory", "Work>Code", true, []Tag{{Key: "category", Value: "Work"}, {Key: "subcategory", Value: "Code"}}},
+		{"nested subcategory", "Work>Code>Go", true, []Tag{{Key: "category", Value: "Work"}, {Key: "subcategory", Value: "Code>Go"}}},
+		{"no subcategory", uncategorized, true, []Tag{{Key: "category", Value: uncategorized}, {Key: "subcategory", Value: ""}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := categoryTags(test.category, ">", test.subcategoryTag); !slices.Equal(got, test.want) {
+				t.Errorf("categoryTags(%q, %t) = %v, want %v", test.category, test.subcategoryTag, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleApiErrorStreams(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	defer func(output io.Writer, logger *log.Logger) {
+		log.SetOutput(output)
+		errorLog = logger
+	}(log.Writer(), errorLog)
+	log.SetOutput(&stdout)
+	errorLog = log.New(&stderr, "", 0)
+	var apiErrors atomic.Int64
+	wg := &sync.WaitGroup{}
+	for i := range 50 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			handleApiError(fmt.Sprintf("Error trying to get events for bucket=%d:", i), errors.New("stub error"), &apiErrors)
+		}()
+		go func() {
+			defer wg.Done()
+			log.Printf("Fetched bucket=%d\n", i)
+		}()
+	}
+	wg.Wait()
+	if apiErrors.Load() != 50 {
+		t.Errorf("api errors = %d, want 50", apiErrors.Load())
+	}
+	tests := []struct {
+		name   string
+		output string
+		prefix string
+	}{
+		{"stdout", stdout.String(), "Fetched bucket="},
+		{"stderr", stderr.String(), "Error trying to get events for bucket="},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := strings.Split(strings.TrimSuffix(test.output, "\n"), "\n")
+			if len(lines) != 50 {
+				t.Errorf("got %d lines, want 50", len(lines))
+			}
+			for _, line := range lines {
+				if !strings.Contains(line, test.prefix) {
+					t.Errorf("unexpected line %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeString(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "main.go - exporter", "main.go - exporter"},
+		{"nul", "a\x00b", "ab"},
+		{"bell", "build done\a", "build done"},
+		{"ansi escape", "\x1b[1;31merror\x1b[0m", "[1;31merror[0m"},
+		{"delete", "a\x7fb", "ab"},
+		{"c1 control", "a\u0085b\u009bc", "abc"},
+		{"newline", "first\nsecond", "first second"},
+		{"carriage return and newline", "first\r\nsecond", "first second"},
+		{"tab", "a\tb", "a b"},
+		{"broken utf-8", "caf\xc3 \xff\xfebar", "caf bar"},
+		{"truncated multi-byte", "日本\xe8\xaa", "日本"},
+		{"multi-byte", "naïve 日本 🚀", "naïve 日本 🚀"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sanitizeString(test.value); got != test.want {
+				t.Errorf("sanitizeString(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapedValuesSanitized(t *testing.T) {
+	value := "nul\x00 bell\a \x1b[0m broken\xff"
+	tests := []struct {
+		name   string
+		escape func(string) string
+		want   string
+	}{
+		{"measurement", escapeMeasurement, `nul\ bell\ [0m\ broken`},
+		{"tag value", escapeTagValue, `nul\ bell\ [0m\ broken`},
+		{"field value", escapeFieldValue, "nul bell [0m broken"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.escape(value); got != test.want {
+				t.Errorf("escaped %q to %q, want %q", value, got, test.want)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryableTransportContext(t *testing.T) {
+	unavailable := func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable", Body: http.NoBody}, nil
+	}
+	ok := func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	// the proxy stored the lines before timing out waiting for InfluxDB
+	badGateway := func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway", Body: http.NoBody}, nil
+	}
+	refused := func(*http.Request) (*http.Response, error) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	}
+	reset := func(*http.Request) (*http.Response, error) {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	tests := []struct {
+		name           string
+		method         string
+		retryWrites    bool
+		timeout        time.Duration
+		attemptTimeout time.Duration
+		cancelAfter    int
+		responses      []func(*http.Request) (*http.Response, error)
+		attempts       int
+		status         int
+		err            error
+		maxElapsed     time.Duration
+	}{
+		{"cancelled during the backoff", "GET", false, 0, 0, 1, nil, 1, 0, context.Canceled, 500 * time.Millisecond},
+		{"backoff past the deadline", "GET", false, 500 * time.Millisecond, 0, 0, nil, 1, http.StatusServiceUnavailable, nil, 400 * time.Millisecond},
+		{
+			"attempt timeout", "GET", false, 500 * time.Millisecond, 50 * time.Millisecond, 0,
+			[]func(*http.Request) (*http.Response, error){func(req *http.Request) (*http.Response, error) {
+				<-req.Context().Done()
+				return nil, req.Context().Err()
+			}},
+			1, 0, context.DeadlineExceeded, 400 * time.Millisecond,
+		},
+		{"retried after the backoff", "GET", false, 0, 0, 0, []func(*http.Request) (*http.Response, error){unavailable, ok}, 2, http.StatusOK, nil, 1500 * time.Millisecond},
+		{"write not retried after a bad gateway", "POST", false, 0, 0, 0, []func(*http.Request) (*http.Response, error){badGateway, ok}, 1, http.StatusBadGateway, nil, 500 * time.Millisecond},
+		{"write retried after a bad gateway with RetryWrites", "POST", true, 0, 0, 0, []func(*http.Request) (*http.Response, error){badGateway, ok}, 2, http.StatusOK, nil, 1500 * time.Millisecond},
+		{"write not retried after a reset connection", "POST", false, 0, 0, 0, []func(*http.Request) (*http.Response, error){reset, ok}, 1, 0, syscall.ECONNRESET, 500 * time.Millisecond},
+		{"write retried after a refused connection", "POST", false, 0, 0, 0, []func(*http.Request) (*http.Response, error){refused, ok}, 2, http.StatusOK, nil, 1500 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			if test.timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			attempts := 0
+			stored := make(map[string]int)
+			transport := &retryableTransport{attemptTimeout: test.attemptTimeout, retryWrites: test.retryWrites, activityWatchHost: "localhost:5600", transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				attempts++
+				if attempts == test.cancelAfter {
+					cancel()
+				}
+				response := unavailable
+				if len(test.responses) > 0 {
+					response = test.responses[min(attempts, len(test.responses))-1]
+				}
+				resp, err := response(req)
+				if req.Body != nil && (resp != nil || !requestNotSent(err)) {
+					data, _ := io.ReadAll(req.Body)
+					for _, line := range strings.SplitAfter(string(data), "\n") {
+						if line != "" {
+							stored[line]++
+						}
+					}
+				}
+				return resp, err
+			})}
+			payload := "currentwindow,app=Code duration=30.000 1741944413\ncurrentwindow,app=Firefox duration=12.000 1741944443\n"
+			req, _ := http.NewRequestWithContext(ctx, test.method, "http://localhost:5600/api/0/buckets", nil)
+			if test.method == "POST" {
+				req, _ = http.NewRequestWithContext(ctx, test.method, "http://influxdb:8086/api/v2/write", strings.NewReader(payload))
+			}
+			start := time.Now()
+			resp, err := transport.RoundTrip(req)
+			elapsed := time.Since(start)
+			if !errors.Is(err, test.err) {
+				t.Errorf("RoundTrip() error = %v, want %v", err, test.err)
+			}
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			if status != test.status {
+				t.Errorf("RoundTrip() status = %d, want %d", status, test.status)
+			}
+			if attempts != test.attempts {
+				t.Errorf("got %d attempts, want %d", attempts, test.attempts)
+			}
+			if elapsed > test.maxElapsed {
+				t.Errorf("RoundTrip() took %s, want at most %s", elapsed, test.maxElapsed)
+			}
+			if test.method != "POST" {
+				return
+			}
+			// the lines are stored once unless RetryWrites retries a write stored by the proxy
+			want := 1
+			if test.retryWrites {
+				want = attempts
+			}
+			for _, line := range strings.SplitAfter(payload, "\n")[:2] {
+				if stored[line] != want {
+					t.Errorf("line %q stored %d times, want %d", line, stored[line], want)
+				}
+			}
+			if len(stored) != 2 {
+				t.Errorf("stored %d distinct lines, want 2", len(stored))
+			}
+		})
+	}
+}
+
+func TestSleepContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		duration time.Duration
+		err      error
+	}{
+		{"elapsed", context.Background(), time.Millisecond, nil},
+		{"cancelled", cancelled, time.Hour, context.Canceled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := sleepContext(test.ctx, test.duration); !errors.Is(err, test.err) {
+				t.Errorf("sleepContext() = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// TestMain runs the exporter instead of the tests when the exit code tests start the test binary again
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("AW_EXPORTER_MAIN_ARGS"); ok {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		os.Args = append([]string{os.Args[0]}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExitCodes(t *testing.T) {
+	now := time.Now().UTC()
+	events := []Event{{ID: 1, Timestamp: now.Add(-time.Hour), Duration: 30, Data: json.RawMessage(`{"app":"Code","title":"main.go"}`)}}
+	tests := []struct {
+		name        string
+		config      map[string]any
+		args        string
+		buckets     int
+		status      map[string]int
+		delay       time.Duration
+		unreachable bool
+		writeStatus int
+		want        int
+	}{
+		{"success", nil, "", 2, nil, 0, false, http.StatusNoContent, 0},
+		{"invalid config", map[string]any{"Precision": "minutes"}, "", 2, nil, 0, false, http.StatusNoContent, exitConfig},
+		{"compression level", map[string]any{"CompressionLevel": 9}, "", 2, nil, 0, false, http.StatusNoContent, 0},
+		{"compression level out of range", map[string]any{"CompressionLevel": 10}, "", 2, nil, 0, false, http.StatusNoContent, exitConfig},
+		{"compression level as a string", map[string]any{"CompressionLevel": "none"}, "", 2, nil, 0, false, http.StatusNoContent, exitConfig},
+		{"hashed fields", map[string]any{"HashSensitiveValues": true, "HashSalt": "salt", "HashedFields": []string{"url", "title"}}, "", 2, nil, 0, false, http.StatusNoContent, 0},
+		{"unknown hashed field", map[string]any{"HashSensitiveValues": true, "HashSalt": "salt", "HashedFields": []string{"url", "app"}}, "", 2, nil, 0, false, http.StatusNoContent, exitConfig},
+		{"invalid flag", nil, "-no-such-flag", 2, nil, 0, false, http.StatusNoContent, exitConfig},
+		{"help", nil, "-h", 2, nil, 0, false, http.StatusNoContent, 0},
+		{"activitywatch unreachable", nil, "", 2, nil, 0, true, http.StatusNoContent, exitFetch},
+		{"every bucket failed", nil, "", 1, map[string]int{"aw-watcher-window_host00": http.StatusForbidden}, 0, false, http.StatusNoContent, exitFetch},
+		{"write rejected", nil, "", 2, nil, 0, false, http.StatusBadRequest, exitWrite},
+		{"token rejected", map[string]any{"SkipTokenCheck": false}, "", 2, nil, 0, false, http.StatusUnauthorized, exitWrite},
+		{"some buckets failed", nil, "", 2, map[string]int{"aw-watcher-window_host01": http.StatusForbidden}, 0, false, http.StatusNoContent, exitPartial},
+		{"timeout", nil, "-timeout 300ms", 2, nil, 5 * time.Second, false, http.StatusNoContent, exitTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &activityWatchStub{buckets: testBuckets(test.buckets), events: make(map[string][]Event), status: test.status, delay: test.delay}
+			for _, entry := range stub.buckets {
+				stub.events[entry.ID] = events
+			}
+			aw := httptest.NewServer(stub)
+			defer aw.Close()
+			if test.unreachable {
+				aw.Close()
+			}
+			influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.writeStatus)
+			}))
+			defer influx.Close()
+			code, output := runExporter(t, aw.URL, influx.URL, test.config, test.args)
+			if code != test.want {
+				t.Errorf("exit code %d, want %d, output:\n%s", code, test.want, output)
+			}
+		})
+	}
+}
+
+// runExporter runs the exporter in a new process of the test binary with a config file
+// reading from the ActivityWatch stub and writing to the InfluxDB stub
+func runExporter(t *testing.T, awUrl string, influxUrl string, options map[string]any, args string) (int, []byte) {
+	config := map[string]any{
+		"ActivityWatchUrl":  awUrl,
+		"WriteURL":          influxUrl + "/api/v2/write",
+		"SkipTokenCheck":    true,
+		"BucketListTimeout": "500ms",
+	}
+	maps.Copy(config, options)
+	configData, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "activitywatch_exporter.json"), configData, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.CommandContext(t.Context(), os.Args[0], "-test.run=^$")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "AW_EXPORTER_MAIN_ARGS="+args)
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), output
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, output
+}
+
+func TestIncompleteFetchDerivedPoints(t *testing.T) {
+	now := time.Now().UTC()
+	var events []Event
+	for i := range 4 {
+		events = append(events, Event{ID: i + 1, Timestamp: now.Add(-time.Duration(60-i*20) * time.Minute), Duration: 60, Data: json.RawMessage(`{"app":"Code","title":"main.go"}`)})
+	}
+	tests := []struct {
+		name    string
+		stream  bool
+		args    string
+		status  map[string]int
+		slow    map[string]time.Duration
+		derived bool
+	}{
+		{"complete", false, "", nil, nil, true},
+		{"failed bucket", false, "", map[string]int{"aw-watcher-window_host01": http.StatusForbidden}, nil, false},
+		{"timeout", false, "-timeout 1s -flush-on-timeout", nil, map[string]time.Duration{"aw-watcher-window_host01": time.Minute}, false},
+		{"complete stream", true, "", nil, nil, true},
+		{"failed bucket stream", true, "", map[string]int{"aw-watcher-window_host01": http.StatusForbidden}, nil, false},
+		{"timeout stream", true, "-timeout 1s -flush-on-timeout", nil, map[string]time.Duration{"aw-watcher-window_host01": time.Minute}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &activityWatchStub{buckets: testBuckets(2), events: make(map[string][]Event), status: test.status, slow: test.slow}
+			for _, entry := range stub.buckets {
+				stub.events[entry.ID] = events
+			}
+			aw := httptest.NewServer(stub)
+			defer aw.Close()
+			mu := &sync.Mutex{}
+			measurements := make(map[string]int)
+			influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+					measurement, _, _ := strings.Cut(line, ",")
+					measurements[measurement]++
+				}
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer influx.Close()
+			options := map[string]any{"DisableGzip": true, "Rollups": []string{rollupAppDaily}, "StreamBatches": test.stream}
+			if !test.stream {
+				options["GapThreshold"] = "1m"
+				options["SessionGap"] = "10m"
+			}
+			code, output := runExporter(t, aw.URL, influx.URL, options, test.args)
+			if code == exitConfig || measurements[currentWindowType] == 0 {
+				t.Fatalf("exit code %d with %v written, output:\n%s", code, measurements, output)
+			}
+			for _, measurement := range []string{"aw_app_daily", "aw_gap", "aw_session"} {
+				if test.stream && measurement != "aw_app_daily" {
+					continue
+				}
+				if written := measurements[measurement] > 0; written != test.derived {
+					t.Errorf("wrote %s=%t, want %t, output:\n%s", measurement, written, test.derived, output)
+				}
+			}
+		})
+	}
+}
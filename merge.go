@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"time"
+)
+
+func mergeEvents(events []Event, window time.Duration) []Event {
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	var merged []Event
+	var lastEnd time.Time
+	for _, event := range events {
+		last := len(merged) - 1
+		if last >= 0 && bytes.Equal(merged[last].Data, event.Data) && event.Timestamp.Sub(lastEnd) < window {
+			merged[last].Duration += event.Duration
+			if end := eventEnd(event); end.After(lastEnd) {
+				lastEnd = end
+			}
+			continue
+		}
+		merged = append(merged, event)
+		lastEnd = eventEnd(event)
+	}
+	return merged
+}
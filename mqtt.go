@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const mqttDisconnectQuiesce = 250
+
+var mqttTopicPlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+var invalidMqttTopicChars = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+func mqttTopic(template string, point Point) string {
+	return mqttTopicPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		key := match[1 : len(match)-1]
+		value := point.Tag(key)
+		if key == "type" || key == "measurement" {
+			value = point.Measurement
+		}
+		if value == "" {
+			value = unknownValue
+		}
+		return invalidMqttTopicChars.Replace(value)
+	})
+}
+
+func connectMqtt(config Config) (mqtt.Client, error) {
+	tlsConfig, err := clientTLSConfig("MqttCAFile", config.MqttCAFile, config.MqttInsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.MqttBroker).
+		SetClientID(config.MqttClientID).
+		SetUsername(config.MqttUsername).
+		SetPassword(config.MqttPassword).
+		SetTLSConfig(tlsConfig).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetConnectRetry(false).
+		SetConnectTimeout(config.writeTimeout)
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(config.writeTimeout) {
+		return nil, fmt.Errorf("error connecting to the MQTT broker: timed out after %s", config.writeTimeout)
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("error connecting to the MQTT broker: %w", token.Error())
+	}
+	return client, nil
+}
+
+func waitMqttTokens(tokens []mqtt.Token, deadline time.Time) error {
+	for _, token := range tokens {
+		if !token.WaitTimeout(time.Until(deadline)) {
+			return fmt.Errorf("error sending data: publish timed out")
+		}
+		if token.Error() != nil {
+			return fmt.Errorf("error sending data: %w", token.Error())
+		}
+	}
+	return nil
+}
+
+func writeMqtt(ctx context.Context, config Config, points []Point) (int, error) {
+	if len(config.MqttMeasurements) > 0 {
+		points = slices.DeleteFunc(slices.Clone(points), func(point Point) bool {
+			return !slices.Contains(config.MqttMeasurements, point.Measurement)
+		})
+	}
+	client, err := connectMqtt(config)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Disconnect(mqttDisconnectQuiesce)
+	latest := make(map[string]int)
+	for i, point := range points {
+		topic := mqttTopic(config.MqttTopic, point)
+		if last, ok := latest[topic]; !ok || !point.Time.Before(points[last].Time) {
+			latest[topic] = i
+		}
+	}
+	var written int
+	var tokens []mqtt.Token
+	deadline := time.Now().Add(config.writeTimeout)
+	for i, point := range points {
+		if ctx.Err() != nil {
+			return written, ctx.Err()
+		}
+		payload, err := json.Marshal(point)
+		if err != nil {
+			return written, err
+		}
+		topic := mqttTopic(config.MqttTopic, point)
+		retained := config.MqttRetain && latest[topic] == i
+		tokens = append(tokens, client.Publish(topic, byte(config.MqttQoS), retained, payload))
+		written += len(payload)
+		if len(tokens) == config.BatchSize {
+			err = waitMqttTokens(tokens, deadline)
+			if err != nil {
+				return written, err
+			}
+			tokens = tokens[:0]
+			deadline = time.Now().Add(config.writeTimeout)
+		}
+	}
+	err = waitMqttTokens(tokens, deadline)
+	if err != nil {
+		return written, err
+	}
+	debugf("Published %d messages to %s\n", len(points), config.MqttBroker)
+	return written, nil
+}
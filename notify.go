@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+const notifyFormatText = "text"
+const notifyFormatSlack = "slack"
+const defaultNotifyTemplate = "activitywatch_exporter failed on {{.Hostname}} while {{.Stage}}: {{.Message}}{{if .FirstError}} (API errors: {{.Errors}}, first error: {{.FirstError}}){{end}}"
+const notifyRecoveryMessage = "activitywatch_exporter succeeded again on %s after a failed run"
+const runStatusFailed = "failed"
+const runStatusOk = "ok"
+
+var firstApiError atomic.Pointer[string]
+
+type Failure struct {
+	Hostname   string
+	Stage      string
+	Message    string
+	Errors     int64
+	FirstError string
+}
+
+func validateNotify(config *Config) {
+	if config.NotifyURL == "" {
+		return
+	}
+	notifyUrl, err := url.Parse(config.NotifyURL)
+	if err != nil || (notifyUrl.Scheme != "http" && notifyUrl.Scheme != "https") {
+		log.Fatalf("Invalid NotifyURL %q, must be an http or https URL\n", config.NotifyURL)
+	}
+	if config.NotifyFormat == "" {
+		config.NotifyFormat = notifyFormatText
+		if notifyUrl.Host == "hooks.slack.com" {
+			config.NotifyFormat = notifyFormatSlack
+		}
+	}
+	if config.NotifyFormat != notifyFormatText && config.NotifyFormat != notifyFormatSlack {
+		log.Fatalf("Invalid NotifyFormat %q, must be %q or %q\n", config.NotifyFormat, notifyFormatText, notifyFormatSlack)
+	}
+	if config.NotifyTemplate == "" {
+		config.NotifyTemplate = defaultNotifyTemplate
+	}
+	config.notifyTemplate, err = template.New("notify").Parse(config.NotifyTemplate)
+	if err != nil {
+		log.Fatalf("Invalid NotifyTemplate %q: %s\n", config.NotifyTemplate, err)
+	}
+	if config.NotifyStateFile == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			cacheDir = os.TempDir()
+		}
+		config.NotifyStateFile = filepath.Join(cacheDir, "activitywatch_exporter", "last_run")
+	}
+}
+
+func sendNotification(config Config, message string) {
+	var body []byte
+	contentType := "text/plain; charset=utf-8"
+	if config.NotifyFormat == notifyFormatSlack {
+		body, _ = json.Marshal(map[string]string{"text": message})
+		contentType = "application/json"
+	} else {
+		body = []byte(message)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", config.NotifyURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if config.NotifyAuthorization != "" {
+		req.Header.Set("Authorization", config.NotifyAuthorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Println("Warning: unable to send the notification:", err)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := readBody(resp.Body, config.maxResponseSize)
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Warning: unable to send the notification: %s: %s\n", resp.Status, apiErrorMessage(respBody))
+	}
+}
+
+func previousRunStatus(config Config) string {
+	status, _ := os.ReadFile(config.NotifyStateFile)
+	return strings.TrimSpace(string(status))
+}
+
+func saveRunStatus(config Config, status string) {
+	err := os.MkdirAll(filepath.Dir(config.NotifyStateFile), 0700)
+	if err == nil {
+		err = os.WriteFile(config.NotifyStateFile, []byte(status+"\n"), 0600)
+	}
+	if err != nil {
+		log.Println("Warning: unable to save the run status:", err)
+	}
+}
+
+func notifySuccess(config Config) {
+	if config.NotifyURL == "" || !config.NotifyRecovery {
+		return
+	}
+	if previousRunStatus(config) == runStatusFailed {
+		hostname, _ := os.Hostname()
+		sendNotification(config, fmt.Sprintf(notifyRecoveryMessage, hostname))
+	}
+	saveRunStatus(config, runStatusOk)
+}
+
+const exitConfig = 1
+const exitFetch = 2
+const exitWrite = 3
+const exitPartial = 4
+const exitTimeout = 5
+
+var runTimedOut atomic.Bool
+
+func failRun(config Config, code int, stage string, apiErrors int64, message string) {
+	if config.NotifyURL != "" {
+		failure := Failure{Stage: stage, Message: message, Errors: apiErrors}
+		failure.Hostname, _ = os.Hostname()
+		if first := firstApiError.Load(); first != nil {
+			failure.FirstError = *first
+		}
+		var text strings.Builder
+		err := config.notifyTemplate.Execute(&text, failure)
+		if err != nil {
+			log.Println("Warning: unable to render NotifyTemplate:", err)
+		} else {
+			sendNotification(config, text.String())
+		}
+		if config.NotifyRecovery {
+			saveRunStatus(config, runStatusFailed)
+		}
+	}
+	if runTimedOut.Load() {
+		code = exitTimeout
+	}
+	log.Println(message)
+	os.Exit(code)
+}
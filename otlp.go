@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	buildinfo "runtime/debug"
+	"slices"
+	"strconv"
+)
+
+const otlpScope = "activitywatch_exporter"
+
+type OtlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type OtlpAttribute struct {
+	Key   string    `json:"key"`
+	Value OtlpValue `json:"value"`
+}
+
+type OtlpDataPoint struct {
+	Attributes   []OtlpAttribute `json:"attributes"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsDouble     float64         `json:"asDouble"`
+}
+
+type OtlpMetric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	Gauge struct {
+		DataPoints []OtlpDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+type OtlpScopeMetrics struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	Metrics []*OtlpMetric `json:"metrics"`
+}
+
+type OtlpResourceMetrics struct {
+	Resource struct {
+		Attributes []OtlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []OtlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type OtlpRequest struct {
+	ResourceMetrics []OtlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func exporterVersion() string {
+	if info, ok := buildinfo.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+func otlpUnit(field string) string {
+	switch field {
+	case "duration", "duration_sum", "audible_duration":
+		return "s"
+	case "duration_ms":
+		return "ms"
+	default:
+		return ""
+	}
+}
+
+func otlpRequest(points []Point) OtlpRequest {
+	hostMetrics := make(map[string]map[string]*OtlpMetric)
+	for _, point := range points {
+		hostname := point.Tag("hostname")
+		if hostMetrics[hostname] == nil {
+			hostMetrics[hostname] = make(map[string]*OtlpMetric)
+		}
+		var attributes []OtlpAttribute
+		for _, tag := range slices.Concat(point.Tags, labelTags(point)) {
+			if tag.Key != "hostname" {
+				attributes = append(attributes, OtlpAttribute{Key: tag.Key, Value: OtlpValue{StringValue: tag.Value}})
+			}
+		}
+		for _, field := range point.Fields {
+			value, ok := sampleValue(field.Value)
+			if !ok {
+				continue
+			}
+			name := "aw." + point.Measurement + "." + field.Key
+			metric, ok := hostMetrics[hostname][name]
+			if !ok {
+				metric = &OtlpMetric{Name: name, Unit: otlpUnit(field.Key)}
+				hostMetrics[hostname][name] = metric
+			}
+			metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, OtlpDataPoint{
+				Attributes:   attributes,
+				TimeUnixNano: strconv.FormatInt(point.Time.UnixNano(), 10),
+				AsDouble:     value,
+			})
+		}
+	}
+	var request OtlpRequest
+	for _, hostname := range slices.Sorted(maps.Keys(hostMetrics)) {
+		var resource OtlpResourceMetrics
+		resource.Resource.Attributes = []OtlpAttribute{
+			{Key: "service.name", Value: OtlpValue{StringValue: otlpScope}},
+			{Key: "service.version", Value: OtlpValue{StringValue: exporterVersion()}},
+			{Key: "host.name", Value: OtlpValue{StringValue: hostname}},
+		}
+		var scope OtlpScopeMetrics
+		scope.Scope.Name = otlpScope
+		scope.Scope.Version = exporterVersion()
+		for _, name := range slices.Sorted(maps.Keys(hostMetrics[hostname])) {
+			scope.Metrics = append(scope.Metrics, hostMetrics[hostname][name])
+		}
+		resource.ScopeMetrics = []OtlpScopeMetrics{scope}
+		request.ResourceMetrics = append(request.ResourceMetrics, resource)
+	}
+	return request
+}
+
+func writeOtlp(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	var written int
+	for start := 0; start < len(points); start += config.BatchSize {
+		body, err := json.Marshal(otlpRequest(points[start:min(start+config.BatchSize, len(points))]))
+		if err != nil {
+			return written, err
+		}
+		err = postOtlp(ctx, client, config, body)
+		if err != nil {
+			return written, err
+		}
+		written += len(body)
+	}
+	return written, nil
+}
+
+func postOtlp(ctx context.Context, client *http.Client, config Config, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", config.OtlpEndpoint, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range config.OtlpHeaders {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return fmt.Errorf("error reading data: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error sending data: %s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	return nil
+}
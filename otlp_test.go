@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestOtlpRequestAttributes(t *testing.T) {
+	request := otlpRequest(testLabelPoints())
+	if len(request.ResourceMetrics) != 1 {
+		t.Fatalf("got %d resources, want 1", len(request.ResourceMetrics))
+	}
+	want := map[string]string{
+		"aw.afkstatus.duration":       "[{status {not-afk}}]",
+		"aw.web.tab.current.duration": "[{url {github.com:8080}}]",
+		"aw.web.tab.current.audible":  "[{url {github.com:8080}}]",
+	}
+	metrics := request.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != len(want) {
+		t.Fatalf("got %d metrics, want %d", len(metrics), len(want))
+	}
+	for _, metric := range metrics {
+		if len(metric.Gauge.DataPoints) != 1 {
+			t.Fatalf("metric %s has %d data points, want 1", metric.Name, len(metric.Gauge.DataPoints))
+		}
+		if attributes := fmt.Sprint(metric.Gauge.DataPoints[0].Attributes); attributes != want[metric.Name] {
+			t.Errorf("metric %s attributes = %s, want %s", metric.Name, attributes, want[metric.Name])
+		}
+	}
+}
+
+// TestOtlpRequestSchema checks the request against the OTLP/JSON encoding of ExportMetricsServiceRequest:
+// lowerCamelCase names, 64 bit integers as decimal strings and AnyValue attributes. The data points are
+// gauges, which have no aggregationTemporality unlike the sums and histograms
+func TestOtlpRequestSchema(t *testing.T) {
+	version, _ := json.Marshal(exporterVersion())
+	want := `{"resourceMetrics": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "activitywatch_exporter"}},
+			{"key": "service.version", "value": {"stringValue": ` + string(version) + `}},
+			{"key": "host.name", "value": {"stringValue": "laptop.example.org"}}
+		]},
+		"scopeMetrics": [{
+			"scope": {"name": "activitywatch_exporter", "version": ` + string(version) + `},
+			"metrics": [
+				{"name": "aw.afkstatus.duration", "unit": "s", "gauge": {"dataPoints": [
+					{"attributes": [{"key": "status", "value": {"stringValue": "not-afk"}}], "timeUnixNano": "1741944413000000000", "asDouble": 30}
+				]}},
+				{"name": "aw.web.tab.current.audible", "gauge": {"dataPoints": [
+					{"attributes": [{"key": "url", "value": {"stringValue": "github.com:8080"}}], "timeUnixNano": "1741944413000000000", "asDouble": 1}
+				]}},
+				{"name": "aw.web.tab.current.duration", "unit": "s", "gauge": {"dataPoints": [
+					{"attributes": [{"key": "url", "value": {"stringValue": "github.com:8080"}}], "timeUnixNano": "1741944413000000000", "asDouble": 12.5}
+				]}}
+			]
+		}]
+	}]}`
+	body, err := json.Marshal(otlpRequest(testLabelPoints()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, expected any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("otlpRequest() = %s, want %s", body, want)
+	}
+}
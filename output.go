@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const formatCSV = "csv"
+const formatJSONL = "jsonl"
+const formatParquet = "parquet"
+const formatLineProtocol = "line"
+
+var formatNames = []string{formatCSV, formatJSONL, formatParquet, formatLineProtocol}
+
+var csvHeader = []string{"timestamp", "type", "hostname", "client", "app", "title", "url", "domain", "project", "language", "file", "label", "status", "duration_seconds", "audible", "incognito", "running"}
+
+type countingWriter struct {
+	writer  io.Writer
+	written int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += n
+	return n, err
+}
+
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return os.Stdout, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the output file: %w", err)
+	}
+	return file, nil
+}
+
+func pointDuration(point Point) (float64, bool) {
+	for _, field := range point.Fields {
+		value, _ := sampleValue(field.Value)
+		switch field.Key {
+		case "duration", "duration_sum":
+			return value, true
+		case "duration_ms":
+			return value / 1000, true
+		}
+	}
+	return 0, false
+}
+
+func csvDuration(point Point) string {
+	if duration, ok := pointDuration(point); ok {
+		return strconv.FormatFloat(duration, 'f', 3, 64)
+	}
+	return ""
+}
+
+func csvField(point Point, key string) string {
+	for _, field := range point.Fields {
+		if field.Key == key {
+			return fmt.Sprint(field.Value)
+		}
+	}
+	return ""
+}
+
+func csvRecord(point Point) []string {
+	return []string{
+		point.Time.UTC().Format(time.RFC3339Nano),
+		point.Measurement,
+		point.Tag("hostname"),
+		point.Tag("client"),
+		point.Tag("app"),
+		point.Title,
+		point.Tag("url"),
+		point.Tag("domain"),
+		point.Tag("project"),
+		point.Tag("language"),
+		point.Tag("file"),
+		point.Tag("label"),
+		csvField(point, "status"),
+		csvDuration(point),
+		csvField(point, "audible"),
+		csvField(point, "incognito"),
+		csvField(point, "running"),
+	}
+}
+
+func writeJSONL(writer io.Writer, points []Point) error {
+	encoder := json.NewEncoder(writer)
+	for _, point := range points {
+		err := encoder.Encode(point)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OutputWriter writes the points to the output file as they are added, the parquet rows
+// are written one row group at a time
+type OutputWriter struct {
+	config   Config
+	file     io.WriteCloser
+	buffered *bufio.Writer
+	counter  *countingWriter
+	records  *csv.Writer
+	rows     *ParquetWriter
+	err      error
+}
+
+func newOutputWriter(config Config, file io.WriteCloser) *OutputWriter {
+	buffered := bufio.NewWriter(file)
+	writer := &OutputWriter{config: config, file: file, buffered: buffered, counter: &countingWriter{writer: buffered}}
+	if config.Format == formatCSV {
+		writer.records = csv.NewWriter(writer.counter)
+		writer.err = writer.records.Write(csvHeader)
+	}
+	if config.Format == formatParquet {
+		writer.rows = newParquetWriter(writer.counter)
+	}
+	return writer
+}
+
+func (writer *OutputWriter) Write(ctx context.Context, points []Point) {
+	if writer.err != nil {
+		return
+	}
+	switch writer.config.Format {
+	case formatJSONL:
+		writer.err = writeJSONL(writer.counter, points)
+	case formatParquet:
+		writer.err = writer.rows.Write(points)
+	case formatLineProtocol:
+		_, writer.err = writer.counter.Write(linesPayload(points, writer.config.precision))
+	default:
+		for _, point := range points {
+			writer.err = writer.records.Write(csvRecord(point))
+			if writer.err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close writes the parquet footer or flushes the rows, and returns the bytes written
+func (writer *OutputWriter) Close(ctx context.Context) (int, error) {
+	err := writer.err
+	if err == nil && writer.rows != nil {
+		err = writer.rows.Close()
+	}
+	if err == nil && writer.records != nil {
+		writer.records.Flush()
+		err = writer.records.Error()
+	}
+	if err == nil {
+		err = writer.buffered.Flush()
+	}
+	if writer.file != os.Stdout {
+		closeErr := writer.file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return writer.counter.written, fmt.Errorf("error writing the output: %w", err)
+	}
+	return writer.counter.written, nil
+}
+
+func writeOutput(config Config, points []Point) (int, error) {
+	file, err := openOutput(config.Output)
+	if err != nil {
+		return 0, err
+	}
+	writer := newOutputWriter(config, file)
+	writer.Write(context.Background(), points)
+	return writer.Close(context.Background())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error {
+	return nil
+}
+
+func TestWriteCSVTitle(t *testing.T) {
+	rules := []RedactionRule{{Field: "title", Pattern: `[\w.]+@[\w.]+`, Replacement: "email", regex: regexp.MustCompile(`[\w.]+@[\w.]+`)}}
+	hashed := Config{location: time.UTC, RedactionRules: rules, HashSensitiveValues: true, HashSalt: "salt", HashedFields: defaultHashedFields}
+	tests := []struct {
+		name       string
+		config     Config
+		bucketType string
+		data       string
+		want       string
+	}{
+		{"window", Config{location: time.UTC}, currentWindowType, `{"app":"Code","title":"main.go - \"exporter\", go"}`, `main.go - "exporter", go`},
+		{"redacted window", Config{location: time.UTC, RedactionRules: rules}, currentWindowType, `{"app":"Thunderbird","title":"Inbox - me@example.com"}`, "Inbox - email"},
+		{"redacted tab", Config{location: time.UTC, RedactionRules: rules}, webTabCurrentType, `{"url":"https://mail.example.com/","title":"me@example.com"}`, "email"},
+		{"hashed", hashed, currentWindowType, `{"app":"Thunderbird","title":"Inbox - me@example.com"}`, protectValue(hashed, "title", "Inbox - email")},
+		{"no title", Config{location: time.UTC}, stopwatchType, `{"label":"writing"}`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bufferCloser
+			writer := newOutputWriter(Config{Format: formatCSV}, &buf)
+			writer.Write(t.Context(), bucketPoints(test.config, testBucket(test.bucketType), testEvents(test.data), &Summary{}))
+			if _, err := writer.Close(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatalf("error reading the CSV: %s", err)
+			}
+			if len(records) != 2 || !slices.Equal(records[0], csvHeader) {
+				t.Fatalf("got %d records with header %q, want 2 with header %q", len(records), records[0], csvHeader)
+			}
+			if title := records[1][slices.Index(csvHeader, "title")]; title != test.want {
+				t.Errorf("title = %q, want %q", title, test.want)
+			}
+		})
+	}
+}
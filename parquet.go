@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var parquetRowGroupSize = 128 * 1024
+
+type ParquetRow struct {
+	Timestamp time.Time `parquet:"timestamp,timestamp(microsecond)"`
+	Type      string    `parquet:"type"`
+	Hostname  *string   `parquet:"hostname,optional"`
+	Client    *string   `parquet:"client,optional"`
+	App       *string   `parquet:"app,optional"`
+	UrlHost   *string   `parquet:"url_host,optional"`
+	Project   *string   `parquet:"project,optional"`
+	Language  *string   `parquet:"language,optional"`
+	File      *string   `parquet:"file,optional"`
+	Label     *string   `parquet:"label,optional"`
+	Duration  *float64  `parquet:"duration,optional"`
+	Audible   *bool     `parquet:"audible,optional"`
+	Incognito *bool     `parquet:"incognito,optional"`
+	Running   *bool     `parquet:"running,optional"`
+	Status    *string   `parquet:"status,optional"`
+}
+
+func optionalTag(point Point, key string) *string {
+	if value := point.Tag(key); value != "" {
+		return &value
+	}
+	return nil
+}
+
+func optionalField[T any](point Point, key string) *T {
+	for _, field := range point.Fields {
+		if value, ok := field.Value.(T); ok && field.Key == key {
+			return &value
+		}
+	}
+	return nil
+}
+
+func parquetRow(point Point) ParquetRow {
+	row := ParquetRow{
+		Timestamp: point.Time,
+		Type:      point.Measurement,
+		Hostname:  optionalTag(point, "hostname"),
+		Client:    optionalTag(point, "client"),
+		App:       optionalTag(point, "app"),
+		UrlHost:   optionalTag(point, "url"),
+		Project:   optionalTag(point, "project"),
+		Language:  optionalTag(point, "language"),
+		File:      optionalTag(point, "file"),
+		Label:     optionalTag(point, "label"),
+		Audible:   optionalField[bool](point, "audible"),
+		Incognito: optionalField[bool](point, "incognito"),
+		Running:   optionalField[bool](point, "running"),
+		Status:    optionalField[string](point, "status"),
+	}
+	if duration, ok := pointDuration(point); ok {
+		row.Duration = &duration
+	}
+	return row
+}
+
+// ParquetWriter converts the points to rows as they are written and flushes a row group
+// every parquetRowGroupSize rows, so only the current row group is kept in memory
+type ParquetWriter struct {
+	rows     *parquet.GenericWriter[ParquetRow]
+	batch    []ParquetRow
+	buffered int
+}
+
+func newParquetWriter(writer io.Writer) *ParquetWriter {
+	return &ParquetWriter{rows: parquet.NewGenericWriter[ParquetRow](writer, parquet.Compression(&parquet.Snappy))}
+}
+
+func (writer *ParquetWriter) Write(points []Point) error {
+	for len(points) > 0 {
+		count := min(len(points), parquetRowGroupSize-writer.buffered)
+		writer.batch = writer.batch[:0]
+		for _, point := range points[:count] {
+			writer.batch = append(writer.batch, parquetRow(point))
+		}
+		_, err := writer.rows.Write(writer.batch)
+		if err != nil {
+			return err
+		}
+		points = points[count:]
+		writer.buffered += count
+		if writer.buffered == parquetRowGroupSize {
+			err = writer.rows.Flush()
+			if err != nil {
+				return err
+			}
+			writer.buffered = 0
+		}
+	}
+	return nil
+}
+
+// Close writes the last row group and the footer
+func (writer *ParquetWriter) Close() error {
+	return writer.rows.Close()
+}
+
+func writeParquet(writer io.Writer, points []Point) error {
+	rows := newParquetWriter(writer)
+	err := rows.Write(points)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
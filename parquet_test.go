@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestWriteParquet(t *testing.T) {
+	config := Config{location: time.UTC}
+	points := bucketPoints(config, testBucket(currentWindowType), testEvents(`{"app":"Code"}`, `{"app":"Firefox"}`), &Summary{})
+	points = append(points, bucketPoints(config, testBucket(webTabCurrentType), testEvents(`{"url":"https://github.com/","audible":true}`), &Summary{})...)
+	points = append(points, bucketPoints(config, testBucket(afkType), testEvents(`{"status":"afk"}`), &Summary{})...)
+	tests := []struct {
+		name   string
+		points []Point
+	}{
+		{"empty", nil},
+		{"mixed types", points},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeParquet(&buf, test.points)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := parquet.Read[ParquetRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("error reading the parquet file: %s", err)
+			}
+			if len(rows) != len(test.points) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(test.points))
+			}
+			for i, row := range rows {
+				want := parquetRow(test.points[i])
+				if !row.Timestamp.Equal(want.Timestamp) || row.Type != want.Type || *row.Duration != *want.Duration {
+					t.Errorf("row %d = %s %s %v, want %s %s %v", i, row.Timestamp, row.Type, *row.Duration, want.Timestamp, want.Type, *want.Duration)
+				}
+				for _, column := range []struct {
+					name      string
+					got, want *string
+				}{{"app", row.App, want.App}, {"url_host", row.UrlHost, want.UrlHost}, {"status", row.Status, want.Status}, {"hostname", row.Hostname, want.Hostname}} {
+					if (column.got == nil) != (column.want == nil) || (column.got != nil && *column.got != *column.want) {
+						t.Errorf("row %d %s = %v, want %v", i, column.name, column.got, column.want)
+					}
+				}
+				if (row.Audible == nil) != (want.Audible == nil) || (row.Audible != nil && *row.Audible != *want.Audible) {
+					t.Errorf("row %d audible = %v, want %v", i, row.Audible, want.Audible)
+				}
+			}
+		})
+	}
+}
+
+func TestParquetWriterRowGroups(t *testing.T) {
+	defer func(size int) {
+		parquetRowGroupSize = size
+	}(parquetRowGroupSize)
+	parquetRowGroupSize = 4
+	var points []Point
+	for i := range 11 {
+		points = append(points, bucketPoints(Config{location: time.UTC}, testBucket(currentWindowType), testEvents(fmt.Sprintf(`{"app":"app %d"}`, i)), &Summary{})...)
+	}
+	tests := []struct {
+		name   string
+		writes []int
+		groups []int64
+	}{
+		{"one write", []int{11}, []int64{4, 4, 3}},
+		{"small writes", []int{1, 2, 3, 5}, []int64{4, 4, 3}},
+		{"full row groups", []int{4, 4}, []int64{4, 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer := newParquetWriter(&buf)
+			written := 0
+			for _, count := range test.writes {
+				err := writer.Write(points[written : written+count])
+				if err != nil {
+					t.Fatal(err)
+				}
+				written += count
+			}
+			err := writer.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("error opening the parquet file: %s", err)
+			}
+			var groups []int64
+			for _, group := range file.RowGroups() {
+				groups = append(groups, group.NumRows())
+			}
+			if fmt.Sprint(groups) != fmt.Sprint(test.groups) {
+				t.Errorf("row groups of %v rows, want %v", groups, test.groups)
+			}
+			rows, err := parquet.Read[ParquetRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("error reading the parquet file: %s", err)
+			}
+			for i, row := range rows {
+				if *row.App != points[i].Tag("app") {
+					t.Errorf("row %d app = %s, want %s", i, *row.App, points[i].Tag("app"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+var fieldValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+var fieldValueLimit = stringLimit
+
+var labelFields = []string{"status"}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+type Field struct {
+	Key   string
+	Value any
+}
+
+type Point struct {
+	Measurement string
+	Tags        []Tag
+	Fields      []Field
+	Time        time.Time
+	BucketID    string
+	EventID     int
+	Title       string
+	Data        map[string]any
+}
+
+// AddTag skips the values left empty once sanitized, InfluxDB rejects the lines with empty tag values
+func (point *Point) AddTag(key string, value string) {
+	if sanitizeString(value) == "" {
+		return
+	}
+	point.Tags = append(point.Tags, Tag{Key: key, Value: value})
+}
+
+func (point *Point) AddTags(tags ...Tag) {
+	for _, tag := range tags {
+		point.AddTag(tag.Key, tag.Value)
+	}
+}
+
+func (point *Point) AddField(key string, value any) {
+	point.Fields = append(point.Fields, Field{Key: key, Value: value})
+}
+
+func (point Point) Tag(key string) string {
+	for _, tag := range point.Tags {
+		if tag.Key == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// labelTags returns the string fields with few distinct values as tags for the backends that
+// only store numbers, per-event values like local_time would create a series for every event
+func labelTags(point Point) []Tag {
+	var tags []Tag
+	for _, field := range point.Fields {
+		if text, ok := field.Value.(string); ok && text != "" && slices.Contains(labelFields, field.Key) {
+			tags = append(tags, Tag{Key: field.Key, Value: text})
+		}
+	}
+	return tags
+}
+
+func durationFields(duration float64, unit string) []Field {
+	seconds := Field{Key: "duration", Value: duration}
+	milliseconds := Field{Key: "duration_ms", Value: int64(math.Round(duration * 1000))}
+	switch unit {
+	case durationMilliseconds:
+		return []Field{milliseconds}
+	case durationBoth:
+		return []Field{seconds, milliseconds}
+	default:
+		return []Field{seconds}
+	}
+}
+
+func formatFieldValue(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return fmt.Sprintf("%.3f", v)
+	case int64:
+		return fmt.Sprintf("%di", v)
+	case int:
+		return fmt.Sprintf("%di", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	default:
+		return fmt.Sprintf("\"%s\"", escapeFieldValue(fmt.Sprint(v)))
+	}
+}
+
+func escapeFieldValue(value string) string {
+	runes := []rune(sanitizeString(value))
+	if len(runes) > fieldValueLimit {
+		runes = append(runes[:fieldValueLimit-3], []rune("...")...)
+	}
+	return fieldValueEscaper.Replace(string(runes))
+}
+
+func (point Point) LineProtocol(precision time.Duration) string {
+	var line strings.Builder
+	line.WriteString(escapeMeasurement(point.Measurement))
+	for _, tag := range point.Tags {
+		fmt.Fprintf(&line, ",%s=%s", tag.Key, escapeTagValue(tag.Value))
+	}
+	for i, field := range point.Fields {
+		separator := ","
+		if i == 0 {
+			separator = " "
+		}
+		fmt.Fprintf(&line, "%s%s=%s", separator, field.Key, formatFieldValue(field.Value))
+	}
+	fmt.Fprintf(&line, " %v\n", point.Time.UnixNano()/int64(precision))
+	return line.String()
+}
+
+// sortPoints orders the points by time, measurement and tags, so the output of
+// identical events doesn't depend on the order the buckets were fetched in
+func sortPoints(points []Point) {
+	slices.SortStableFunc(points, func(a, b Point) int {
+		return cmp.Or(
+			a.Time.Compare(b.Time),
+			cmp.Compare(a.Measurement, b.Measurement),
+			slices.CompareFunc(a.Tags, b.Tags, func(x, y Tag) int {
+				return cmp.Or(cmp.Compare(x.Key, y.Key), cmp.Compare(x.Value, y.Value))
+			}),
+			cmp.Compare(a.BucketID, b.BucketID),
+			cmp.Compare(a.EventID, b.EventID),
+		)
+	})
+}
+
+func linesPayload(points []Point, precision time.Duration) []byte {
+	var payload bytes.Buffer
+	for _, point := range points {
+		payload.WriteString(point.LineProtocol(precision))
+	}
+	return payload.Bytes()
+}
+
+func (point Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Measurement string            `json:"measurement"`
+		Tags        map[string]string `json:"tags"`
+		Fields      map[string]any    `json:"fields"`
+		Time        time.Time         `json:"timestamp"`
+	}{point.Measurement, pointTagsMap(point), pointFieldsMap(point), point.Time})
+}
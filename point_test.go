@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseTestLine splits a line the way InfluxDB does, unescaping the measurement and the tags
+func parseTestLine(line string) (string, map[string]string, string, error) {
+	rest, ok := strings.CutSuffix(line, "\n")
+	if !ok || strings.ContainsAny(rest, "\r\n") {
+		return "", nil, "", errors.New("not a single line")
+	}
+	scan := func(stops string) (string, byte) {
+		var token strings.Builder
+		for i := 0; i < len(rest); i++ {
+			if rest[i] == '\\' && i+1 < len(rest) && strings.IndexByte(`, =\`, rest[i+1]) >= 0 {
+				token.WriteByte(rest[i+1])
+				i++
+				continue
+			}
+			if strings.IndexByte(stops, rest[i]) >= 0 {
+				token, delimiter := token.String(), rest[i]
+				rest = rest[i+1:]
+				return token, delimiter
+			}
+			token.WriteByte(rest[i])
+		}
+		rest = ""
+		return token.String(), 0
+	}
+	measurement, delimiter := scan(", ")
+	tags := make(map[string]string)
+	for delimiter == ',' {
+		var key, value string
+		key, delimiter = scan("=, ")
+		if delimiter != '=' {
+			return "", nil, "", fmt.Errorf("tag %q without a value", key)
+		}
+		value, delimiter = scan(", ")
+		tags[key] = value
+	}
+	i := strings.LastIndexByte(rest, ' ')
+	if measurement == "" || delimiter != ' ' || i <= 0 {
+		return "", nil, "", errors.New("missing the measurement, the fields or the timestamp")
+	}
+	if _, err := strconv.ParseInt(rest[i+1:], 10, 64); err != nil {
+		return "", nil, "", fmt.Errorf("invalid timestamp %q", rest[i+1:])
+	}
+	return measurement, tags, rest[:i], nil
+}
+
+func TestDurationFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		unit     string
+		want     string
+	}{
+		{"seconds", 12.3456, durationSeconds, "duration=12.346"},
+		{"milliseconds", 12.3456, durationMilliseconds, "duration_ms=12346i"},
+		{"both", 12.3456, durationBoth, "duration=12.346,duration_ms=12346i"},
+		{"rounded milliseconds", 0.0005, durationMilliseconds, "duration_ms=1i"},
+		{"zero", 0, durationBoth, "duration=0.000,duration_ms=0i"},
+		{"long event", 864000.0004, durationMilliseconds, "duration_ms=864000000i"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			point := Point{Measurement: "m", Fields: durationFields(test.duration, test.unit), Time: testTime}
+			_, fields, _ := strings.Cut(strings.TrimSuffix(point.LineProtocol(time.Second), "\n"), " ")
+			fields, _, _ = strings.Cut(fields, " ")
+			if fields != test.want {
+				t.Errorf("durationFields(%v, %q) = %s, want %s", test.duration, test.unit, fields, test.want)
+			}
+		})
+	}
+}
+
+func testLabelPoints() []Point {
+	afk := Point{Measurement: afkType, Time: testTime}
+	afk.AddTag("hostname", "laptop.example.org")
+	afk.AddField("duration", 30.0)
+	afk.AddField("status", "not-afk")
+	afk.AddField("local_time", "2025-03-14T10:26:53+01:00")
+	web := Point{Measurement: webTabCurrentType, Time: testTime}
+	web.AddTag("hostname", "laptop.example.org")
+	web.AddTag("url", "github.com:8080")
+	web.AddField("duration", 12.5)
+	web.AddField("audible", true)
+	web.AddField("local_time", "2025-03-14T10:26:53+01:00")
+	return []Point{afk, web}
+}
+
+func TestAddTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []Tag
+	}{
+		{"plain", "Code", []Tag{{Key: "app", Value: "Code"}}},
+		{"empty", "", nil},
+		{"nul", "\x00", nil},
+		{"invalid utf-8", "\xff\xfe", nil},
+		{"control characters", "\x1b\x7f", nil},
+		{"line breaks are kept as spaces", "\r\n", []Tag{{Key: "app", Value: "\r\n"}}},
+		{"nul in a value", "Co\x00de", []Tag{{Key: "app", Value: "Co\x00de"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var point Point
+			point.AddTag("app", test.value)
+			if !slices.Equal(point.Tags, test.want) {
+				t.Errorf("AddTag(%q) = %q, want %q", test.value, point.Tags, test.want)
+			}
+		})
+	}
+}
+
+func TestLabelTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		point Point
+		want  []Tag
+	}{
+		{"status", testLabelPoints()[0], []Tag{{Key: "status", Value: "not-afk"}}},
+		{"no label fields", testLabelPoints()[1], nil},
+		{"empty status", Point{Fields: []Field{{Key: "status", Value: ""}}}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := labelTags(test.point); !slices.Equal(got, test.want) {
+				t.Errorf("labelTags() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeTagValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "Code", "Code"},
+		{"delimiters", "a,b=c d", `a\,b\=c\ d`},
+		{"windows path", `C:\Users\me\main.go`, `C:\Users\me\main.go`},
+		{"trailing backslash", `C:\Users\me\`, `C:\Users\me\\`},
+		{"trailing backslashes", `share\\`, `share\\\\`},
+		{"backslash before a space", `C:\Program Files\ app`, `C:\Program\ Files\\\ app`},
+		{"backslash before a comma", `a\,b`, `a\\\,b`},
+		{"newline", "first\nsecond", `first\ second`},
+		{"carriage return and newline", "first\r\nsecond", `first\ second`},
+		{"tab", "a\tb", `a\ b`},
+		{"multi-byte", "naïve 日本", `naïve\ 日本`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := escapeTagValue(test.value); got != test.want {
+				t.Errorf("escapeTagValue(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLineProtocolWindowsPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"trailing backslash", `C:\Users\me\`, `app.editor.activity,file=C:\Users\me\\,project=exporter duration=1.000 1741944413` + "\n"},
+		{"newline", "main.go\nrest", `app.editor.activity,file=main.go\ rest,project=exporter duration=1.000 1741944413` + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			point := Point{Measurement: appEditorType, Time: testTime}
+			point.AddTag("file", test.file)
+			point.AddTag("project", "exporter")
+			point.AddField("duration", 1.0)
+			if got := point.LineProtocol(time.Second); got != test.want {
+				t.Errorf("LineProtocol() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeFieldValue(t *testing.T) {
+	defer func(limit int) {
+		fieldValueLimit = limit
+	}(fieldValueLimit)
+	tests := []struct {
+		name  string
+		limit int
+		value string
+		want  string
+	}{
+		{"plain", 10, "afk", "afk"},
+		{"quotes", 20, `say "hi"`, `say \"hi\"`},
+		{"backslashes", 20, `C:\Users\`, `C:\\Users\\`},
+		{"newline", 20, "a\nb", "a b"},
+		{"delimiters are not escaped", 20, "a,b=c d", "a,b=c d"},
+		{"at the limit", 5, "abcde", "abcde"},
+		{"over the limit", 5, "abcdef", "ab..."},
+		{"multi-byte at the limit", 5, "日本語日本", "日本語日本"},
+		{"multi-byte over the limit", 5, "日本語日本語", "日本..."},
+		{"quote at the truncation boundary", 5, `ab"cdef`, `ab...`},
+		{"quote before the truncation boundary", 5, `a"cdef`, `a\"...`},
+		{"backslash before the truncation boundary", 5, `a\cdef`, `a\\...`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fieldValueLimit = test.limit
+			if got := escapeFieldValue(test.value); got != test.want {
+				t.Errorf("escapeFieldValue(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatFieldValue(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{12.3456, "12.346"},
+		{int64(42), "42i"},
+		{42, "42i"},
+		{true, "true"},
+		{false, "false"},
+		{`not "afk"`, `"not \"afk\""`},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.value), func(t *testing.T) {
+			if got := formatFieldValue(test.value); got != test.want {
+				t.Errorf("formatFieldValue(%v) = %s, want %s", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeMeasurement(t *testing.T) {
+	tests := []struct {
+		name        string
+		measurement string
+		want        string
+	}{
+		{"plain", "currentwindow", "currentwindow"},
+		{"dots", "web.tab.current", "web.tab.current"},
+		{"space", "my watcher v2", `my\ watcher\ v2`},
+		{"comma", "watcher,v2", `watcher\,v2`},
+		{"equals sign", "key=value", "key=value"},
+		{"quotes", `"quoted"`, `"quoted"`},
+		{"backslash", `C:\watcher`, `C:\watcher`},
+		{"trailing backslash", `watcher\`, `watcher\\`},
+		{"backslash before a space", `watcher\ v2`, `watcher\\\ v2`},
+		{"newline", "watcher\nv2", `watcher\ v2`},
+		{"control characters", "watcher\x00\x1b", "watcher"},
+		{"multi-byte", "ウォッチャー 2", `ウォッチャー\ 2`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := escapeMeasurement(test.measurement); got != test.want {
+				t.Errorf("escapeMeasurement(%q) = %q, want %q", test.measurement, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLineProtocolMeasurement(t *testing.T) {
+	tests := []struct {
+		measurement string
+		want        string
+	}{
+		{"my watcher v2", "my watcher v2"},
+		{"watcher,v2", "watcher,v2"},
+		{`watcher\`, `watcher\`},
+		{"watcher\nv2", "watcher v2"},
+	}
+	for _, test := range tests {
+		t.Run(test.measurement, func(t *testing.T) {
+			point := Point{Measurement: test.measurement, Time: testTime}
+			point.AddTag("hostname", "laptop")
+			point.AddField("duration", 1.0)
+			line := point.LineProtocol(time.Second)
+			measurement, tags, _, err := parseTestLine(line)
+			if err != nil {
+				t.Fatalf("line %q doesn't parse: %s", line, err)
+			}
+			if measurement != test.want || tags["hostname"] != "laptop" {
+				t.Errorf("line %q parses to measurement=%q tags=%v, want measurement=%q", line, measurement, tags, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeTagValueTruncation(t *testing.T) {
+	a := strings.Repeat("a", stringLimit-4)
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"comma at the limit", a + "aaa,", a + `aaa\,`},
+		{"comma over the limit", a + "aaa,b", a + "a..."},
+		{"comma at the cut", a + ",bcde", a + `\,...`},
+		{"space at the cut", a + " bcde", a + `\ ...`},
+		{"backslash at the cut", a + `\bcde`, a + `\...`},
+		{"trailing backslash at the limit", a + `aaa\`, a + `aaa\\`},
+		{"backslash and comma at the cut", a[1:] + `\,cdef`, a[1:] + `\\\,...`},
+		{"only commas", strings.Repeat(",", stringLimit+1), strings.Repeat(`\,`, stringLimit-3) + "..."},
+		{"multi-byte over the limit", a + "日本語日本", a + "日..."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := escapeTagValue(test.value)
+			if got != test.want {
+				t.Errorf("escapeTagValue() = %q, want %q", strings.TrimPrefix(got, a), strings.TrimPrefix(test.want, a))
+			}
+			point := Point{Measurement: "m", Tags: []Tag{{Key: "t", Value: test.value}}, Fields: []Field{{Key: "f", Value: 1.0}}, Time: testTime}
+			_, tags, _, err := parseTestLine(point.LineProtocol(time.Second))
+			if err != nil {
+				t.Fatalf("line doesn't parse: %s", err)
+			}
+			if length := len([]rune(tags["t"])); length > stringLimit {
+				t.Errorf("parsed tag is %d runes long, want at most %d", length, stringLimit)
+			}
+		})
+	}
+}
+
+func TestSortPoints(t *testing.T) {
+	point := func(measurement string, offset time.Duration, bucketID string, tags ...string) Point {
+		point := Point{Measurement: measurement, Time: testTime.Add(offset), BucketID: bucketID}
+		for i := 0; i+1 < len(tags); i += 2 {
+			point.AddTag(tags[i], tags[i+1])
+		}
+		point.AddField("bucket", bucketID)
+		return point
+	}
+	tests := []struct {
+		name   string
+		points []Point
+	}{
+		{"time", []Point{
+			point(currentWindowType, 0, "b"),
+			point(currentWindowType, time.Second, "a"),
+			point(currentWindowType, time.Minute, "a"),
+		}},
+		{"measurement", []Point{
+			point(afkType, 0, "b"),
+			point(currentWindowType, 0, "a"),
+			point(webTabCurrentType, 0, "a"),
+		}},
+		{"tags", []Point{
+			point(currentWindowType, 0, "c", "app", "Code"),
+			point(currentWindowType, 0, "b", "app", "Code", "title", "main.go"),
+			point(currentWindowType, 0, "a", "app", "Firefox"),
+			point(currentWindowType, 0, "a", "hostname", "laptop"),
+		}},
+		{"identical series from several buckets", []Point{
+			point(currentWindowType, 0, "aw-watcher-window_desktop", "app", "Code"),
+			point(currentWindowType, 0, "aw-watcher-window_laptop", "app", "Code"),
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := linesPayload(test.points, time.Second)
+			// every rotation and its reverse stands for a different order of the fetched buckets
+			for i := range test.points {
+				shuffled := append(slices.Clone(test.points[i:]), test.points[:i]...)
+				reversed := slices.Clone(shuffled)
+				slices.Reverse(reversed)
+				for _, order := range [][]Point{shuffled, reversed} {
+					sortPoints(order)
+					if got := linesPayload(order, time.Second); !bytes.Equal(got, want) {
+						t.Errorf("sorted payload of rotation %d =\n%s\nwant\n%s", i, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const postgresSchema = `CREATE TABLE IF NOT EXISTS %[1]s (
+	time timestamptz NOT NULL,
+	measurement text NOT NULL,
+	bucket text,
+	event_id bigint,
+	tags jsonb NOT NULL,
+	fields jsonb NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS %[2]s ON %[1]s (bucket, event_id) WHERE event_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS %[3]s ON %[1]s (measurement, time, tags) WHERE event_id IS NULL;
+`
+
+const postgresUpsert = `INSERT INTO %[1]s SELECT * FROM aw_staging WHERE event_id IS NOT NULL
+ON CONFLICT (bucket, event_id) WHERE event_id IS NOT NULL
+DO UPDATE SET time = EXCLUDED.time, tags = EXCLUDED.tags, fields = EXCLUDED.fields;
+INSERT INTO %[1]s SELECT * FROM aw_staging WHERE event_id IS NULL
+ON CONFLICT (measurement, time, tags) WHERE event_id IS NULL
+DO UPDATE SET fields = EXCLUDED.fields;
+`
+
+func pointTagsMap(point Point) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range point.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
+
+func pointFieldsMap(point Point) map[string]any {
+	fields := make(map[string]any)
+	for _, field := range point.Fields {
+		fields[field.Key] = field.Value
+	}
+	return fields
+}
+
+// postgresConflictKey returns the key of the unique index the upsert of the point conflicts on,
+// with the time truncated to the microseconds stored by timestamptz
+func postgresConflictKey(point Point) string {
+	if point.EventID != 0 {
+		return fmt.Sprintf("%s\xff%d", point.BucketID, point.EventID)
+	}
+	tags := pointTagsMap(point)
+	var key strings.Builder
+	fmt.Fprintf(&key, "%s\xff%d", point.Measurement, point.Time.UnixMicro())
+	for _, tag := range slices.Sorted(maps.Keys(tags)) {
+		fmt.Fprintf(&key, "\xff%s\xff%s", tag, tags[tag])
+	}
+	return key.String()
+}
+
+// postgresRows keeps the last of the points with the same conflict key,
+// ON CONFLICT DO UPDATE fails when a statement affects a row twice
+func postgresRows(points []Point) [][]any {
+	rows := make([][]any, 0, len(points))
+	index := make(map[string]int)
+	for _, point := range points {
+		var bucket, eventID any
+		if point.EventID != 0 {
+			bucket = point.BucketID
+			eventID = int64(point.EventID)
+		}
+		row := []any{point.Time, point.Measurement, bucket, eventID, pointTagsMap(point), pointFieldsMap(point)}
+		key := postgresConflictKey(point)
+		if i, ok := index[key]; ok {
+			rows[i] = row
+			continue
+		}
+		index[key] = len(rows)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func writePostgresBatch(ctx context.Context, conn *pgx.Conn, table string, points []Point) error {
+	rows := postgresRows(points)
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE aw_staging (LIKE %s) ON COMMIT DROP", table))
+	if err != nil {
+		return err
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"aw_staging"}, []string{"time", "measurement", "bucket", "event_id", "tags", "fields"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, fmt.Sprintf(postgresUpsert, table))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func createPostgresSchema(ctx context.Context, conn *pgx.Conn, config Config) error {
+	_, err := conn.Exec(ctx, fmt.Sprintf(postgresSchema,
+		pgx.Identifier{config.PostgresTable}.Sanitize(),
+		pgx.Identifier{config.PostgresTable + "_event"}.Sanitize(),
+		pgx.Identifier{config.PostgresTable + "_point"}.Sanitize(),
+	))
+	if err != nil {
+		return fmt.Errorf("error creating the PostgreSQL table: %w", err)
+	}
+	return nil
+}
+
+func initPostgresSchema(ctx context.Context, config Config) error {
+	conn, err := pgx.Connect(ctx, config.PostgresDSN)
+	if err != nil {
+		return fmt.Errorf("error connecting to PostgreSQL: %w", err)
+	}
+	defer conn.Close(context.Background())
+	return createPostgresSchema(ctx, conn, config)
+}
+
+func writePostgres(ctx context.Context, config Config, points []Point) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, config.PostgresDSN)
+	if err != nil {
+		return fmt.Errorf("error connecting to PostgreSQL: %w", err)
+	}
+	defer conn.Close(context.Background())
+	err = createPostgresSchema(ctx, conn, config)
+	if err != nil {
+		return err
+	}
+	table := pgx.Identifier{config.PostgresTable}.Sanitize()
+	for start := 0; start < len(points); start += config.BatchSize {
+		err = writePostgresBatch(ctx, conn, table, points[start:min(start+config.BatchSize, len(points))])
+		if err != nil {
+			return fmt.Errorf("error sending data: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPostgresRows(t *testing.T) {
+	rollup := func(app string, duration float64, at time.Time) Point {
+		point := Point{Measurement: "aw_app_daily", Time: at}
+		point.AddTag("hostname", "laptop")
+		point.AddTag("app", app)
+		point.AddField("duration_sum", duration)
+		return point
+	}
+	event := func(bucket string, id int, duration float64) Point {
+		point := Point{Measurement: currentWindowType, Time: testTime, BucketID: bucket, EventID: id}
+		point.AddTag("app", "Code")
+		point.AddField("duration", duration)
+		return point
+	}
+	tests := []struct {
+		name   string
+		points []Point
+		want   []float64
+	}{
+		{"distinct", []Point{rollup("Code", 1, testTime), rollup("Firefox", 2, testTime), event("a", 1, 3), event("a", 2, 4), event("b", 1, 5)}, []float64{1, 2, 3, 4, 5}},
+		{"duplicate series", []Point{rollup("Code", 1, testTime), rollup("Firefox", 2, testTime), rollup("Code", 3, testTime)}, []float64{3, 2}},
+		{"duplicate series within a microsecond", []Point{rollup("Code", 1, testTime), rollup("Code", 2, testTime.Add(999*time.Nanosecond))}, []float64{2}},
+		{"distinct microseconds", []Point{rollup("Code", 1, testTime), rollup("Code", 2, testTime.Add(time.Microsecond))}, []float64{1, 2}},
+		{"tag order", []Point{rollup("Code", 1, testTime), {Measurement: "aw_app_daily", Time: testTime, Tags: []Tag{{Key: "app", Value: "Code"}, {Key: "hostname", Value: "laptop"}}, Fields: []Field{{Key: "duration_sum", Value: 2.0}}}}, []float64{2}},
+		{"duplicate events", []Point{event("a", 1, 1), event("a", 1, 2), event("b", 1, 3)}, []float64{2, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []float64
+			for _, row := range postgresRows(test.points) {
+				for _, value := range row[5].(map[string]any) {
+					got = append(got, value.(float64))
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("postgresRows() durations = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
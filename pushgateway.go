@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const pushgatewayJob = "activitywatch_exporter"
+
+type RunMetrics struct {
+	BucketEvents map[string]int
+	BytesWritten int
+	Duration     time.Duration
+	Errors       int64
+	Success      bool
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func runMetricsText(metrics RunMetrics, now time.Time) []byte {
+	var text bytes.Buffer
+	text.WriteString("# TYPE activitywatch_exporter_events_exported gauge\n")
+	for _, bucket := range slices.Sorted(maps.Keys(metrics.BucketEvents)) {
+		fmt.Fprintf(&text, "activitywatch_exporter_events_exported{bucket=\"%s\"} %d\n", escapeLabelValue(bucket), metrics.BucketEvents[bucket])
+	}
+	fmt.Fprintf(&text, "# TYPE activitywatch_exporter_bytes_written gauge\nactivitywatch_exporter_bytes_written %d\n", metrics.BytesWritten)
+	fmt.Fprintf(&text, "# TYPE activitywatch_exporter_run_duration_seconds gauge\nactivitywatch_exporter_run_duration_seconds %.3f\n", metrics.Duration.Seconds())
+	fmt.Fprintf(&text, "# TYPE activitywatch_exporter_errors gauge\nactivitywatch_exporter_errors %d\n", metrics.Errors)
+	fmt.Fprintf(&text, "# TYPE activitywatch_exporter_last_run_timestamp_seconds gauge\nactivitywatch_exporter_last_run_timestamp_seconds %d\n", now.Unix())
+	if metrics.Success {
+		fmt.Fprintf(&text, "# TYPE activitywatch_exporter_last_success_timestamp_seconds gauge\nactivitywatch_exporter_last_success_timestamp_seconds %d\n", now.Unix())
+	}
+	return text.Bytes()
+}
+
+func pushRunMetrics(ctx context.Context, client *http.Client, config Config, metrics RunMetrics) {
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "unknown"
+	}
+	pushUrl := fmt.Sprintf("%s/metrics/job/%s/instance/%s", config.PushgatewayUrl, pushgatewayJob, url.PathEscape(instance))
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", pushUrl, bytes.NewReader(runMetricsText(metrics, time.Now())))
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Warning: unable to push the run metrics to the Pushgateway:", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := readBody(resp.Body, config.maxResponseSize)
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Warning: unable to push the run metrics to the Pushgateway: %s: %s\n", resp.Status, apiErrorMessage(body))
+		return
+	}
+	debugf("Pushed the run metrics to %s\n", pushUrl)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type QueryRequest struct {
+	Timeperiods []string `json:"timeperiods"`
+	Query       []string `json:"query"`
+}
+
+const queryApiPath = "/api/0/query/"
+
+// nonAfkQuery intersects the window events with the not-afk periods of every afk bucket of the
+// hostname, so the time is afk when any of its afk watchers reports it like with FilterAFK
+func nonAfkQuery(windowBucket string, afkBuckets []string) []string {
+	query := []string{"events = flood(query_bucket(" + strconv.Quote(windowBucket) + "));"}
+	for _, afkBucket := range afkBuckets {
+		query = append(query,
+			"not_afk = flood(query_bucket("+strconv.Quote(afkBucket)+"));",
+			`not_afk = filter_keyvals(not_afk, "status", ["not-afk"]);`,
+			"events = filter_period_intersect(events, not_afk);",
+		)
+	}
+	return append(query, "RETURN = events;")
+}
+
+func dailyTimeperiods(start time.Time, end time.Time) []string {
+	var timeperiods []string
+	for periodStart := start; periodStart.Before(end); periodStart = periodStart.AddDate(0, 0, 1) {
+		periodEnd := periodStart.AddDate(0, 0, 1)
+		if periodEnd.After(end) {
+			periodEnd = end
+		}
+		timeperiods = append(timeperiods, periodStart.Format(time.RFC3339Nano)+"/"+periodEnd.Format(time.RFC3339Nano))
+	}
+	return timeperiods
+}
+
+func queryNonAfkEvents(ctx context.Context, client *http.Client, config Config, windowBucket string, afkBuckets []string, start time.Time, end time.Time) ([]Event, error) {
+	query, err := json.Marshal(QueryRequest{
+		Timeperiods: dailyTimeperiods(start, end),
+		Query:       nonAfkQuery(windowBucket, afkBuckets),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.eventsTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", activityWatchApiUrl(config, queryApiPath), bytes.NewReader(query))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending the query request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp.Body, config.maxResponseSize)
+	if errors.Is(err, errResponseTooLarge) {
+		return nil, fmt.Errorf("%w, consider reducing -days", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading query data: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error response to the query request: %s: %s", resp.Status, apiErrorMessage(body))
+	}
+	var periods [][]Event
+	err = json.Unmarshal(body, &periods)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling query data %s: %w", apiErrorMessage(body), err)
+	}
+	var events []Event
+	for _, periodEvents := range periods {
+		events = append(events, periodEvents...)
+	}
+	return events, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNonAfkQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		afkBuckets []string
+		want       []string
+	}{
+		{"one afk bucket", []string{"aw-watcher-afk_laptop"}, []string{
+			`events = flood(query_bucket("aw-watcher-window_laptop"));`,
+			`not_afk = flood(query_bucket("aw-watcher-afk_laptop"));`,
+			`not_afk = filter_keyvals(not_afk, "status", ["not-afk"]);`,
+			`events = filter_period_intersect(events, not_afk);`,
+			`RETURN = events;`,
+		}},
+		{"two afk buckets", []string{"aw-watcher-afk_laptop", "aw-watcher-afk_laptop-2"}, []string{
+			`events = flood(query_bucket("aw-watcher-window_laptop"));`,
+			`not_afk = flood(query_bucket("aw-watcher-afk_laptop"));`,
+			`not_afk = filter_keyvals(not_afk, "status", ["not-afk"]);`,
+			`events = filter_period_intersect(events, not_afk);`,
+			`not_afk = flood(query_bucket("aw-watcher-afk_laptop-2"));`,
+			`not_afk = filter_keyvals(not_afk, "status", ["not-afk"]);`,
+			`events = filter_period_intersect(events, not_afk);`,
+			`RETURN = events;`,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := nonAfkQuery("aw-watcher-window_laptop", test.afkBuckets)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("nonAfkQuery() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
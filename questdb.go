@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+)
+
+func questDBKey(encoded string) (*ecdsa.PrivateKey, error) {
+	d, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QuestDBPrivateKey: %w", err)
+	}
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d)
+	return key, nil
+}
+
+func authenticateQuestDB(conn net.Conn, reader *bufio.Reader, config Config) error {
+	key, err := questDBKey(config.QuestDBPrivateKey)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "%s\n", config.QuestDBKeyID)
+	if err != nil {
+		return err
+	}
+	challenge, err := reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("error reading the authentication challenge: %w", err)
+	}
+	hash := sha256.Sum256(challenge[:len(challenge)-1])
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "%s\n", base64.StdEncoding.EncodeToString(signature))
+	return err
+}
+
+func writeQuestDB(ctx context.Context, config Config, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if config.QuestDBTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", config.QuestDBAddress)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", config.QuestDBAddress)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to QuestDB: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if config.QuestDBKeyID != "" {
+		err = authenticateQuestDB(conn, bufio.NewReader(conn), config)
+		if err != nil {
+			return fmt.Errorf("error authenticating to QuestDB: %w", err)
+		}
+	}
+	writer := bufio.NewWriter(conn)
+	_, err = writer.Write(payload)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "aw"
+
+var redisKeySubjects = []string{"app", "domain", "url", "label", "status"}
+
+func redisKey(point Point) string {
+	hostname := point.Tag("hostname")
+	if hostname == "" {
+		hostname = unknownValue
+	}
+	key := []string{redisKeyPrefix, hostname, point.Measurement}
+	tags := slices.Concat(point.Tags, labelTags(point))
+	for _, subject := range redisKeySubjects {
+		i := slices.IndexFunc(tags, func(tag Tag) bool {
+			return tag.Key == subject
+		})
+		if i >= 0 {
+			key = append(key, tags[i].Value)
+			break
+		}
+	}
+	return strings.Join(key, ":")
+}
+
+func redisAddArgs(config Config, point Point, duration float64) []any {
+	args := []any{"TS.ADD", redisKey(point), point.Time.UnixMilli(), duration, "ON_DUPLICATE", "LAST"}
+	if config.redisRetention > 0 {
+		args = append(args, "RETENTION", config.redisRetention.Milliseconds())
+	}
+	args = append(args, "LABELS", "type", point.Measurement)
+	for _, tag := range point.Tags {
+		args = append(args, tag.Key, tag.Value)
+	}
+	return args
+}
+
+func connectRedis(ctx context.Context, config Config) (*redis.Client, error) {
+	options := &redis.Options{
+		Addr:         config.RedisAddress,
+		Username:     config.RedisUsername,
+		Password:     config.RedisPassword,
+		DB:           config.RedisDB,
+		DialTimeout:  config.writeTimeout,
+		ReadTimeout:  config.writeTimeout,
+		WriteTimeout: config.writeTimeout,
+	}
+	if config.RedisTLS {
+		tlsConfig, err := clientTLSConfig("RedisCAFile", config.RedisCAFile, config.RedisInsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		options.TLSConfig = tlsConfig
+	}
+	client := redis.NewClient(options)
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error connecting to Redis: %w", err)
+	}
+	return client, nil
+}
+
+func writeRedis(ctx context.Context, config Config, points []Point) error {
+	client, err := connectRedis(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	var samples, skippedFields int
+	pipe := client.Pipeline()
+	for _, point := range points {
+		for _, field := range point.Fields {
+			if _, ok := field.Value.(string); ok {
+				skippedFields++
+			}
+		}
+		duration, ok := pointDuration(point)
+		if !ok {
+			continue
+		}
+		pipe.Do(ctx, redisAddArgs(config, point, duration)...)
+		samples++
+		if pipe.Len() == config.BatchSize {
+			_, err = pipe.Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("error sending data: %w", err)
+			}
+		}
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	if skippedFields > 0 {
+		log.Printf("Warning: skipped %d string fields, RedisTimeSeries only stores numeric values\n", skippedFields)
+	}
+	debugf("Added %d samples to RedisTimeSeries at %s\n", samples, config.RedisAddress)
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestRedisKey(t *testing.T) {
+	points := testLabelPoints()
+	tests := []struct {
+		name  string
+		point Point
+		want  string
+	}{
+		{"status", points[0], "aw:laptop.example.org:afkstatus:not-afk"},
+		{"url", points[1], "aw:laptop.example.org:web.tab.current:github.com:8080"},
+		{"no hostname", Point{Measurement: stopwatchType, Tags: []Tag{{Key: "label", Value: "writing"}}}, "aw:unknown:general.stopwatch:writing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := redisKey(test.point); got != test.want {
+				t.Errorf("redisKey() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/golang/snappy"
+)
+
+const remoteWriteBatchSize = 1000
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+func metricName(measurement string, field string) string {
+	if !strings.HasPrefix(measurement, "aw_") {
+		measurement = "aw_" + measurement
+	}
+	switch field {
+	case "duration":
+		field = "duration_seconds"
+	case "duration_ms":
+		field = "duration_milliseconds"
+	}
+	return invalidMetricChars.ReplaceAllString(measurement+"_"+field, "_")
+}
+
+func sampleValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func pointsToTimeSeries(points []Point) []TimeSeries {
+	var series []TimeSeries
+	index := make(map[string]int)
+	for _, point := range points {
+		var labels []Label
+		for _, tag := range point.Tags {
+			labels = append(labels, Label{Name: invalidMetricChars.ReplaceAllString(tag.Key, "_"), Value: tag.Value})
+		}
+		for _, tag := range labelTags(point) {
+			labels = append(labels, Label{Name: invalidMetricChars.ReplaceAllString(tag.Key, "_"), Value: tag.Value})
+		}
+		for _, field := range point.Fields {
+			value, ok := sampleValue(field.Value)
+			if !ok {
+				continue
+			}
+			seriesLabels := append([]Label{{Name: "__name__", Value: metricName(point.Measurement, field.Key)}}, labels...)
+			slices.SortFunc(seriesLabels, func(a, b Label) int {
+				return strings.Compare(a.Name, b.Name)
+			})
+			var key strings.Builder
+			for _, label := range seriesLabels {
+				fmt.Fprintf(&key, "%s\xff%s\xff", label.Name, label.Value)
+			}
+			i, ok := index[key.String()]
+			if !ok {
+				i = len(series)
+				index[key.String()] = i
+				series = append(series, TimeSeries{Labels: seriesLabels})
+			}
+			series[i].Samples = append(series[i].Samples, Sample{Value: value, Timestamp: point.Time.UnixMilli()})
+		}
+	}
+	for _, s := range series {
+		slices.SortStableFunc(s.Samples, func(a, b Sample) int {
+			return cmp.Compare(a.Timestamp, b.Timestamp)
+		})
+	}
+	return series
+}
+
+func appendVarint(buf []byte, field int, value uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3))
+	return binary.AppendUvarint(buf, value)
+}
+
+func appendBytes(buf []byte, field int, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field<<3|2))
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func encodeWriteRequest(series []TimeSeries) []byte {
+	var request []byte
+	for _, s := range series {
+		var encoded []byte
+		for _, label := range s.Labels {
+			var l []byte
+			l = appendBytes(l, 1, []byte(label.Name))
+			l = appendBytes(l, 2, []byte(label.Value))
+			encoded = appendBytes(encoded, 1, l)
+		}
+		for _, sample := range s.Samples {
+			var v []byte
+			v = binary.AppendUvarint(v, 1<<3|1)
+			v = binary.LittleEndian.AppendUint64(v, math.Float64bits(sample.Value))
+			v = appendVarint(v, 2, uint64(sample.Timestamp))
+			encoded = appendBytes(encoded, 2, v)
+		}
+		request = appendBytes(request, 1, encoded)
+	}
+	return request
+}
+
+func writeRemoteWrite(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	var written int
+	series := pointsToTimeSeries(points)
+	for start := 0; start < len(series); start += remoteWriteBatchSize {
+		batch := series[start:min(start+remoteWriteBatchSize, len(series))]
+		body := snappy.Encode(nil, encodeWriteRequest(batch))
+		err := postRemoteWrite(ctx, client, config, body)
+		if err != nil {
+			return written, err
+		}
+		written += len(body)
+	}
+	debugf("Sent %d series to the remote write endpoint\n", len(series))
+	return written, nil
+}
+
+func postRemoteWrite(ctx context.Context, client *http.Client, config Config, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	post, _ := http.NewRequestWithContext(ctx, "POST", config.RemoteWriteUrl, bytes.NewReader(body))
+	post.Header.Set("Content-Encoding", "snappy")
+	post.Header.Set("Content-Type", "application/x-protobuf")
+	post.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if config.RemoteWriteUsername != "" {
+		post.SetBasicAuth(config.RemoteWriteUsername, config.RemoteWritePassword)
+	}
+	for key, value := range config.RemoteWriteHeaders {
+		post.Header.Set(key, value)
+	}
+	resp, err := client.Do(post)
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return fmt.Errorf("error reading data: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		if resp.StatusCode == http.StatusBadRequest {
+			log.Println("Warning: remote write endpoints usually reject samples older than a couple of hours, use a short --days range")
+		}
+		return fmt.Errorf("error sending data: %s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	return nil
+}
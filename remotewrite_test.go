@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPointsToTimeSeriesLabels(t *testing.T) {
+	series := pointsToTimeSeries(testLabelPoints())
+	want := []string{
+		"[{__name__ aw_afkstatus_duration_seconds} {hostname laptop.example.org} {status not-afk}]",
+		"[{__name__ aw_web_tab_current_duration_seconds} {hostname laptop.example.org} {url github.com:8080}]",
+		"[{__name__ aw_web_tab_current_audible} {hostname laptop.example.org} {url github.com:8080}]",
+	}
+	if len(series) != len(want) {
+		t.Fatalf("got %d series, want %d: %v", len(series), len(want), series)
+	}
+	for i, s := range series {
+		if labels := fmt.Sprint(s.Labels); labels != want[i] {
+			t.Errorf("series %d labels = %s, want %s", i, labels, want[i])
+		}
+		if len(s.Samples) != 1 || s.Samples[0].Timestamp != testTime.UnixMilli() {
+			t.Errorf("series %d samples = %v, want one sample at %d", i, s.Samples, testTime.UnixMilli())
+		}
+	}
+}
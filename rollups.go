@@ -0,0 +1,225 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const rollupAppDaily = "app_daily"
+const rollupWebDaily = "web_daily"
+const rollupEditorProjectDaily = "editor_project_daily"
+const rollupEditorLanguageDaily = "editor_language_daily"
+const unknownValue = "unknown"
+
+var rollupNames = []string{rollupAppDaily, rollupWebDaily, rollupEditorProjectDaily, rollupEditorLanguageDaily}
+
+// RollupKey holds the hostname and device tags once resolved, so the totals of the hostnames
+// renamed to the same alias are summed instead of overwriting each other
+type RollupKey struct {
+	RollupHost
+	Day   time.Time
+	Value string
+}
+
+type RollupTotal struct {
+	Duration        float64
+	AudibleDuration float64
+	Events          int64
+	files           map[string]bool
+}
+
+type RollupHost struct {
+	Hostname string
+	Device   string
+}
+
+type RollupSample struct {
+	Value   string
+	Audible bool
+	File    string
+}
+
+type Rollup struct {
+	Measurement string
+	Tag         string
+	Audible     bool
+	Files       bool
+	start       time.Time
+	totals      map[RollupKey]*RollupTotal
+}
+
+func newRollup(measurement string, tag string, start time.Time) *Rollup {
+	return &Rollup{Measurement: measurement, Tag: tag, start: start, totals: make(map[RollupKey]*RollupTotal)}
+}
+
+func startOfDay(t time.Time, location *time.Location) time.Time {
+	local := t.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+}
+
+func (rollup *Rollup) total(key RollupKey) *RollupTotal {
+	total, ok := rollup.totals[key]
+	if !ok {
+		total = &RollupTotal{files: make(map[string]bool)}
+		rollup.totals[key] = total
+	}
+	return total
+}
+
+func (rollup *Rollup) Add(host RollupHost, location *time.Location, event Event, sample RollupSample) {
+	start := event.Timestamp
+	end := eventEnd(event)
+	// the day of the start of the exported range is only partially fetched
+	// for some hostnames and would overwrite its complete totals
+	firstDay := startOfDay(rollup.start, location)
+	day := startOfDay(start, location)
+	if !day.Before(firstDay) {
+		first := rollup.total(RollupKey{RollupHost: host, Day: day, Value: sample.Value})
+		first.Events++
+		if sample.File != "" {
+			first.files[sample.File] = true
+		}
+	}
+	for ; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if day.Before(firstDay) {
+			continue
+		}
+		next := day.AddDate(0, 0, 1)
+		from := start
+		if day.After(from) {
+			from = day
+		}
+		to := end
+		if next.Before(to) {
+			to = next
+		}
+		total := rollup.total(RollupKey{RollupHost: host, Day: day, Value: sample.Value})
+		total.Duration += to.Sub(from).Seconds()
+		if sample.Audible {
+			total.AudibleDuration += to.Sub(from).Seconds()
+		}
+	}
+}
+
+func (rollup *Rollup) Points(config Config) []Point {
+	var points []Point
+	keys := slices.SortedFunc(maps.Keys(rollup.totals), func(a, b RollupKey) int {
+		return cmp.Or(
+			cmp.Compare(a.Hostname, b.Hostname),
+			cmp.Compare(a.Device, b.Device),
+			a.Day.Compare(b.Day),
+			cmp.Compare(a.Value, b.Value),
+		)
+	})
+	for _, key := range keys {
+		total := rollup.totals[key]
+		point := Point{Measurement: rollup.Measurement, Time: key.Day}
+		point.AddTag("hostname", key.Hostname)
+		point.AddTag("device", key.Device)
+		point.AddTag(rollup.Tag, key.Value)
+		point.AddField("duration_sum", total.Duration)
+		point.AddField("event_count", total.Events)
+		if rollup.Audible {
+			point.AddField("audible_duration", total.AudibleDuration)
+		}
+		if rollup.Files {
+			point.AddField("file_count", int64(len(total.files)))
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+type Rollups struct {
+	appDaily            *Rollup
+	webDaily            *Rollup
+	editorProjectDaily  *Rollup
+	editorLanguageDaily *Rollup
+}
+
+func newRollups(names []string, start time.Time) *Rollups {
+	rollups := new(Rollups)
+	if slices.Contains(names, rollupAppDaily) {
+		rollups.appDaily = newRollup("aw_app_daily", "app", start)
+	}
+	if slices.Contains(names, rollupWebDaily) {
+		rollups.webDaily = newRollup("aw_web_daily", "domain", start)
+		rollups.webDaily.Audible = true
+	}
+	if slices.Contains(names, rollupEditorProjectDaily) {
+		rollups.editorProjectDaily = newRollup("aw_editor_project_daily", "project", start)
+		rollups.editorProjectDaily.Files = true
+	}
+	if slices.Contains(names, rollupEditorLanguageDaily) {
+		rollups.editorLanguageDaily = newRollup("aw_editor_language_daily", "language", start)
+		rollups.editorLanguageDaily.Files = true
+	}
+	return rollups
+}
+
+func (rollups *Rollups) AddBucket(config Config, entry Bucket, events []Event) {
+	location := hostnameLocation(config, entry.Hostname)
+	host := RollupHost{Hostname: resolveHostname(config, entry.Hostname)}
+	if len(config.Devices) > 0 {
+		host.Device = resolveDevice(config, entry.Hostname)
+	}
+	var redactions atomic.Int64
+	for _, event := range events {
+		switch {
+		case entry.Type == currentWindowType && rollups.appDaily != nil:
+			data := new(CurrentWindow)
+			if json.Unmarshal(event.Data, data) != nil || !isAppExported(data.App, config.AppAllowlist, config.AppBlocklist) {
+				continue
+			}
+			app := redactValue(config.RedactionRules, "app", data.App, &redactions)
+			if app == "" {
+				continue
+			}
+			rollups.appDaily.Add(host, location, event, RollupSample{Value: app})
+		case entry.Type == webTabCurrentType && rollups.webDaily != nil:
+			data := new(WebTabCurrent)
+			if json.Unmarshal(event.Data, data) != nil || (config.ExcludeIncognito && data.Incognito) {
+				continue
+			}
+			u, err := url.Parse(redactValue(config.RedactionRules, "url", data.URL, &redactions))
+			if err != nil || u.Hostname() == "" || !isDomainExported(u.Hostname(), config.WebDomainAllowlist, config.WebDomainBlocklist) {
+				continue
+			}
+			domain := protectValue(config, "domain", registrableDomain(strings.ToLower(u.Hostname())))
+			rollups.webDaily.Add(host, location, event, RollupSample{Value: domain, Audible: data.Audible})
+		case entry.Type == appEditorType && (rollups.editorProjectDaily != nil || rollups.editorLanguageDaily != nil):
+			data := new(AppEditorActivity)
+			if json.Unmarshal(event.Data, data) != nil {
+				continue
+			}
+			file := redactValue(config.RedactionRules, "file", data.File, &redactions)
+			if file == "" {
+				continue
+			}
+			project := cmp.Or(redactValue(config.RedactionRules, "project", data.Project, &redactions), unknownValue)
+			language := cmp.Or(data.Language, unknownValue)
+			if rollups.editorProjectDaily != nil {
+				rollups.editorProjectDaily.Add(host, location, event, RollupSample{Value: project, File: file})
+			}
+			if rollups.editorLanguageDaily != nil {
+				rollups.editorLanguageDaily.Add(host, location, event, RollupSample{Value: language, File: file})
+			}
+		}
+	}
+}
+
+func (rollups *Rollups) Points(config Config) []Point {
+	var points []Point
+	for _, rollup := range []*Rollup{rollups.appDaily, rollups.webDaily, rollups.editorProjectDaily, rollups.editorLanguageDaily} {
+		if rollup != nil {
+			points = append(points, rollup.Points(config)...)
+		}
+	}
+	return points
+}
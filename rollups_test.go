@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRollupLeadingDay(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, time.March, 14, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		location *time.Location
+		events   []Event
+		want     map[string]float64
+	}{
+		{
+			"first day complete since midnight",
+			time.UTC,
+			[]Event{
+				{Timestamp: time.Date(2025, time.March, 14, 1, 0, 0, 0, time.UTC), Duration: 600},
+				{Timestamp: time.Date(2025, time.March, 14, 10, 0, 0, 0, time.UTC), Duration: 60},
+				{Timestamp: time.Date(2025, time.March, 15, 8, 0, 0, 0, time.UTC), Duration: 30},
+			},
+			map[string]float64{"2025-03-14": 660, "2025-03-15": 30},
+		},
+		{
+			"earlier day dropped",
+			time.UTC,
+			[]Event{
+				{Timestamp: time.Date(2025, time.March, 13, 23, 0, 0, 0, time.UTC), Duration: 600},
+				{Timestamp: time.Date(2025, time.March, 14, 10, 0, 0, 0, time.UTC), Duration: 60},
+			},
+			map[string]float64{"2025-03-14": 60},
+		},
+		{
+			"event across the first midnight",
+			time.UTC,
+			[]Event{
+				{Timestamp: time.Date(2025, time.March, 13, 23, 50, 0, 0, time.UTC), Duration: 1200},
+			},
+			map[string]float64{"2025-03-14": 600},
+		},
+		{
+			"event across a later midnight",
+			time.UTC,
+			[]Event{
+				{Timestamp: time.Date(2025, time.March, 14, 23, 50, 0, 0, time.UTC), Duration: 1200},
+			},
+			map[string]float64{"2025-03-14": 600, "2025-03-15": 600},
+		},
+		{
+			"hostname timezone",
+			berlin,
+			[]Event{
+				{Timestamp: time.Date(2025, time.March, 13, 22, 30, 0, 0, time.UTC), Duration: 60},
+				{Timestamp: time.Date(2025, time.March, 13, 23, 30, 0, 0, time.UTC), Duration: 60},
+			},
+			map[string]float64{"2025-03-14": 60},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rollup := newRollup("aw_app_daily", "app", start)
+			for _, event := range test.events {
+				rollup.Add(RollupHost{Hostname: "laptop"}, test.location, event, RollupSample{Value: "Code"})
+			}
+			got := make(map[string]float64)
+			for _, point := range rollup.Points(Config{}) {
+				duration, _ := pointDuration(point)
+				got[point.Time.In(test.location).Format(time.DateOnly)] = duration
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("durations per day = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRollupsAddBucket(t *testing.T) {
+	start := testTime.Add(-time.Hour)
+	tests := []struct {
+		name       string
+		rollup     string
+		bucketType string
+		data       []string
+		want       []string
+	}{
+		{
+			rollupAppDaily, rollupAppDaily, currentWindowType,
+			[]string{`{"app":"Code"}`, `{"app":"Code"}`, `{"app":"Firefox"}`, `{"app":""}`},
+			[]string{
+				"aw_app_daily,hostname=laptop,app=Code duration_sum=60.000,event_count=2i",
+				"aw_app_daily,hostname=laptop,app=Firefox duration_sum=30.000,event_count=1i",
+			},
+		},
+		{
+			rollupWebDaily, rollupWebDaily, webTabCurrentType,
+			[]string{`{"url":"https://docs.github.com/","audible":true}`, `{"url":"https://github.com/"}`, `{"url":"about:blank"}`},
+			[]string{"aw_web_daily,hostname=laptop,domain=github.com duration_sum=60.000,event_count=2i,audible_duration=30.000"},
+		},
+		{
+			rollupEditorProjectDaily, rollupEditorProjectDaily, appEditorType,
+			[]string{`{"file":"/a.go","project":"exporter","language":"go"}`, `{"file":"/a.go","project":"exporter","language":"go"}`, `{"file":"/b.go","language":"go"}`},
+			[]string{
+				"aw_editor_project_daily,hostname=laptop,project=exporter duration_sum=60.000,event_count=2i,file_count=1i",
+				"aw_editor_project_daily,hostname=laptop,project=unknown duration_sum=30.000,event_count=1i,file_count=1i",
+			},
+		},
+		{
+			rollupEditorLanguageDaily, rollupEditorLanguageDaily, appEditorType,
+			[]string{`{"file":"/a.go","project":"exporter","language":"go"}`, `{"file":"/b.go","language":"go"}`, `{"file":"","language":"go"}`},
+			[]string{"aw_editor_language_daily,hostname=laptop,language=go duration_sum=60.000,event_count=2i,file_count=2i"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rollups := newRollups([]string{test.rollup}, start)
+			rollups.AddBucket(Config{location: time.UTC}, testBucket(test.bucketType), testEvents(test.data...))
+			points := rollups.Points(Config{})
+			if len(points) != len(test.want) {
+				t.Fatalf("got %d points, want %d", len(points), len(test.want))
+			}
+			for i, point := range points {
+				line := point.LineProtocol(time.Second)
+				want := fmt.Sprintf("%s %d\n", test.want[i], startOfDay(testTime, time.UTC).Unix())
+				if line != want {
+					t.Errorf("point %d = %q, want %q", i, line, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRollupsSkipMalformedData(t *testing.T) {
+	rollups := newRollups(rollupNames, testTime.Add(-time.Hour))
+	for _, bucketType := range []string{currentWindowType, webTabCurrentType, appEditorType} {
+		rollups.AddBucket(Config{location: time.UTC}, testBucket(bucketType), []Event{{Timestamp: testTime, Duration: 1, Data: json.RawMessage(`[]`)}})
+	}
+	if points := rollups.Points(Config{}); len(points) != 0 {
+		t.Errorf("got %d points from malformed events, want 0", len(points))
+	}
+}
+
+func TestRollupsAliasedHosts(t *testing.T) {
+	start := testTime.Add(-time.Hour)
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{
+			"separate hosts",
+			Config{location: time.UTC},
+			[]string{
+				"aw_app_daily,hostname=desktop,app=Code duration_sum=30.000,event_count=1i",
+				"aw_app_daily,hostname=laptop,app=Code duration_sum=60.000,event_count=2i",
+			},
+		},
+		{
+			"aliases",
+			Config{location: time.UTC, HostnameAliases: map[string]string{"laptop": "work", "desktop": "work"}},
+			[]string{"aw_app_daily,hostname=work,app=Code duration_sum=90.000,event_count=3i"},
+		},
+		{
+			"override",
+			Config{location: time.UTC, HostnameOverride: "me"},
+			[]string{"aw_app_daily,hostname=me,app=Code duration_sum=90.000,event_count=3i"},
+		},
+		{
+			"aliases with devices",
+			Config{location: time.UTC, HostnameAliases: map[string]string{"laptop": "work", "desktop": "work"}, Devices: map[string]string{"laptop": "thinkpad"}},
+			[]string{
+				"aw_app_daily,hostname=work,device=thinkpad,app=Code duration_sum=60.000,event_count=2i",
+				"aw_app_daily,hostname=work,device=work,app=Code duration_sum=30.000,event_count=1i",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			laptop := testBucket(currentWindowType)
+			desktop := Bucket{ID: currentWindowType + "_desktop", Type: currentWindowType, Client: "aw-watcher", Hostname: "desktop"}
+			rollups := newRollups([]string{rollupAppDaily}, start)
+			rollups.AddBucket(test.config, laptop, testEvents(`{"app":"Code"}`, `{"app":"Code"}`))
+			rollups.AddBucket(test.config, desktop, testEvents(`{"app":"Code"}`))
+			points := rollups.Points(test.config)
+			if len(points) != len(test.want) {
+				t.Fatalf("got %d points, want %d", len(points), len(test.want))
+			}
+			for i, point := range points {
+				want := fmt.Sprintf("%s %d\n", test.want[i], startOfDay(testTime, time.UTC).Unix())
+				if line := point.LineProtocol(time.Second); line != want {
+					t.Errorf("point %d = %q, want %q", i, line, want)
+				}
+			}
+		})
+	}
+}
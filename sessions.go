@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+type SessionEvent struct {
+	Start    time.Time
+	End      time.Time
+	Duration float64
+	App      string
+}
+
+type Session struct {
+	Start  time.Time
+	End    time.Time
+	Events int
+	apps   map[string]float64
+}
+
+func (session Session) DominantApp() string {
+	var dominant string
+	for _, app := range slices.Sorted(maps.Keys(session.apps)) {
+		if dominant == "" || session.apps[app] > session.apps[dominant] {
+			dominant = app
+		}
+	}
+	return dominant
+}
+
+func activeWindowEvents(config Config, bucketsList Buckets, bucketEvents map[string][]Event, start time.Time) map[string][]SessionEvent {
+	afk := afkPeriods(bucketsList, bucketEvents)
+	hostEvents := make(map[string][]SessionEvent)
+	for _, entry := range bucketsList {
+		if entry.Type != currentWindowType {
+			continue
+		}
+		for _, event := range bucketEvents[entry.ID] {
+			data := new(CurrentWindow)
+			if json.Unmarshal(event.Data, data) != nil || !isAppExported(data.App, config.AppAllowlist, config.AppBlocklist) {
+				continue
+			}
+			if periods := afk[entry.Hostname]; len(periods) > 0 {
+				clipped, ok := clipToActive(event, periods)
+				if !ok {
+					continue
+				}
+				event = clipped
+			}
+			end := eventEnd(event)
+			if !end.After(start) {
+				continue
+			}
+			if event.Timestamp.Before(start) {
+				event.Duration -= start.Sub(event.Timestamp).Seconds()
+				event.Timestamp = start
+			}
+			hostEvents[entry.Hostname] = append(hostEvents[entry.Hostname], SessionEvent{
+				Start:    event.Timestamp,
+				End:      end,
+				Duration: event.Duration,
+				App:      data.App,
+			})
+		}
+	}
+	return hostEvents
+}
+
+func sessionize(events []SessionEvent, gap time.Duration) []Session {
+	slices.SortStableFunc(events, func(a, b SessionEvent) int {
+		return a.Start.Compare(b.Start)
+	})
+	var sessions []Session
+	for _, event := range events {
+		last := len(sessions) - 1
+		if last < 0 || event.Start.Sub(sessions[last].End) >= gap {
+			sessions = append(sessions, Session{Start: event.Start, End: event.End, apps: make(map[string]float64)})
+			last++
+		}
+		if event.End.After(sessions[last].End) {
+			sessions[last].End = event.End
+		}
+		sessions[last].Events++
+		sessions[last].apps[event.App] += event.Duration
+	}
+	return sessions
+}
+
+func sessionPoints(config Config, bucketsList Buckets, bucketEvents map[string][]Event, start time.Time) []Point {
+	var points []Point
+	hostEvents := activeWindowEvents(config, bucketsList, bucketEvents, start)
+	var redactions atomic.Int64
+	for _, hostname := range slices.Sorted(maps.Keys(hostEvents)) {
+		for _, session := range sessionize(hostEvents[hostname], config.sessionGap) {
+			app := redactValue(config.RedactionRules, "app", session.DominantApp(), &redactions)
+			point := Point{Measurement: "aw_session", Time: session.Start}
+			point.AddTags(hostTags(config, hostname)...)
+			point.AddTag("app", app)
+			point.Fields = durationFields(session.End.Sub(session.Start).Seconds(), config.DurationUnit)
+			point.AddField("event_count", int64(session.Events))
+			points = append(points, point)
+		}
+	}
+	return points
+}
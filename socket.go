@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"time"
+)
+
+const socketChunkSize = 64 * 1024
+
+func socketChunks(payload []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(payload) > 0 {
+		end := len(payload)
+		if end > size {
+			end = bytes.LastIndexByte(payload[:size], '\n') + 1
+			if end == 0 {
+				end = bytes.IndexByte(payload, '\n') + 1
+			}
+		}
+		chunks = append(chunks, payload[:end])
+		payload = payload[end:]
+	}
+	return chunks
+}
+
+func dialSocket(ctx context.Context, config Config) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, config.SocketType, config.SocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", config.SocketPath, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	return conn, nil
+}
+
+func writeSocket(ctx context.Context, config Config, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for _, chunk := range socketChunks(payload, socketChunkSize) {
+		var err error
+		for retries := 0; ; retries++ {
+			if conn == nil {
+				conn, err = dialSocket(ctx, config)
+			}
+			if conn != nil {
+				_, err = conn.Write(chunk)
+			}
+			if err == nil {
+				break
+			}
+			if retries >= retryCount {
+				return fmt.Errorf("error sending data: %w", err)
+			}
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			backoff := time.Duration(math.Pow(2, float64(retries))) * time.Second
+			log.Printf("Warning: error sending data to %s, retrying in %s: %s\n", config.SocketPath, backoff, err)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("error sending data: %w", ctx.Err())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testSocketPath(t *testing.T) string {
+	// unix socket paths are limited to about 100 bytes, shorter than the ones of t.TempDir
+	dir, err := os.MkdirTemp("", "aw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, "telegraf.sock")
+}
+
+func TestSocketChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		size    int
+		want    []string
+	}{
+		{"empty", "", 10, nil},
+		{"one chunk", "a 1\nb 2\n", 10, []string{"a 1\nb 2\n"}},
+		{"split at lines", "a 1\nb 2\nc 3\n", 9, []string{"a 1\nb 2\n", "c 3\n"}},
+		{"line longer than a chunk", "abcdefgh 1\nb 2\n", 5, []string{"abcdefgh 1\n", "b 2\n"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, chunk := range socketChunks([]byte(test.payload), test.size) {
+				got = append(got, string(chunk))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("socketChunks() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteSocketLines(t *testing.T) {
+	path := testSocketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	mu := &sync.Mutex{}
+	received := make(map[string]int)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					mu.Lock()
+					received[scanner.Text()]++
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	var points []Point
+	for i := range 20000 {
+		point := Point{Measurement: currentWindowType, Time: testTime.Add(time.Duration(i) * time.Millisecond)}
+		point.AddTag("app", fmt.Sprintf("app %d", i%7))
+		point.AddField("duration", float64(i))
+		points = append(points, point)
+	}
+	payload := linesPayload(points, time.Millisecond)
+	config := Config{SocketPath: path, SocketType: "unix", writeTimeout: 10 * time.Second}
+	writers := &sync.WaitGroup{}
+	for range 4 {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			err := writeSocket(t.Context(), config, payload)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	writers.Wait()
+	listener.Close()
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(string(payload), "\n"), "\n")
+	if len(received) != len(lines) {
+		t.Errorf("received %d distinct lines, want %d", len(received), len(lines))
+	}
+	for _, line := range lines {
+		if received[line] != 4 {
+			t.Errorf("line %q received %d times, want 4", line, received[line])
+		}
+	}
+}
+
+func TestWriteSocketSlowListener(t *testing.T) {
+	path := testSocketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	config := Config{SocketPath: path, SocketType: "unix", writeTimeout: 300 * time.Millisecond}
+	payload := []byte(strings.Repeat(strings.Repeat("a", 99)+"\n", 1<<17))
+	start := time.Now()
+	err = writeSocket(t.Context(), config, payload)
+	if err == nil {
+		t.Fatal("writeSocket() to a listener that never reads succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("writeSocket() returned after %s, want about the %s write timeout", elapsed, config.writeTimeout)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const spoolSuffix = ".lp.gz"
+
+func spoolEnabled(config Config) bool {
+	return config.SpoolDir != "" && config.Format == "" && config.Backend == backendInfluxDB && config.SocketPath == "" && !strings.HasPrefix(config.WriteURL, udpScheme+"://")
+}
+
+func spoolFiles(config Config) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(config.SpoolDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(entry os.DirEntry) bool {
+		return entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSuffix)
+	}), nil
+}
+
+func spoolPayload(config Config, payload []byte) (string, error) {
+	err := os.MkdirAll(config.SpoolDir, 0700)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(payload)
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(config.SpoolDir, time.Now().UTC().Format("20060102T150405.000000000Z")+"."+config.Precision+spoolSuffix)
+	err = os.WriteFile(name+".tmp", buf.Bytes(), 0600)
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	evictSpool(config)
+	return name, nil
+}
+
+func evictSpool(config Config) {
+	files, err := spoolFiles(config)
+	if err != nil {
+		log.Println("Warning: unable to list the spool directory:", err)
+		return
+	}
+	var size int64
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		info, err := file.Info()
+		if err == nil {
+			sizes[i] = info.Size()
+			size += sizes[i]
+		}
+	}
+	for i := 0; size > config.spoolMaxSize && i < len(files)-1; i++ {
+		log.Printf("Warning: spool directory bigger than SpoolMaxSizeMB, dropping %s\n", files[i].Name())
+		err = os.Remove(filepath.Join(config.SpoolDir, files[i].Name()))
+		if err != nil {
+			log.Println("Warning: unable to remove the spooled payload:", err)
+			continue
+		}
+		size -= sizes[i]
+	}
+}
+
+// spooledConfig returns the config to replay a spooled payload with the precision
+// of its timestamps, saved in its name, instead of the current Precision
+func spooledConfig(config Config, name string) Config {
+	precision := strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(name, spoolSuffix)), ".")
+	if duration, ok := precisions[precision]; ok {
+		config.Precision = precision
+		config.precision = duration
+	}
+	return config
+}
+
+func readSpooled(name string) ([]byte, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func replaySpool(ctx context.Context, client *http.Client, config Config) {
+	files, err := spoolFiles(config)
+	if err != nil {
+		log.Println("Warning: unable to list the spool directory:", err)
+		return
+	}
+	for i, file := range files {
+		name := filepath.Join(config.SpoolDir, file.Name())
+		payload, err := readSpooled(name)
+		if err != nil {
+			log.Printf("Warning: unable to read the spooled payload %s: %s\n", name, err)
+			continue
+		}
+		err = writeInfluxDB(ctx, client, spooledConfig(config, name), payload, &InfluxWriteStats{})
+		if err != nil {
+			log.Printf("Warning: unable to replay the spooled payloads, %d left in %s: %s\n", len(files)-i, config.SpoolDir, err)
+			return
+		}
+		err = os.Remove(name)
+		if err != nil {
+			log.Printf("Warning: unable to remove the replayed payload %s: %s\n", name, err)
+		}
+		log.Printf("Replayed the spooled payload %s\n", file.Name())
+	}
+}
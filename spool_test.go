@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestReplaySpoolPrecision(t *testing.T) {
+	tests := []struct {
+		name            string
+		spoolPrecision  string
+		replayPrecision string
+		want            string
+	}{
+		{"same precision", "s", "s", "s"},
+		{"milliseconds replayed after switching to seconds", "ms", "s", "ms"},
+		{"seconds replayed after switching to nanoseconds", "s", "ns", "s"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []influxRequest
+			server := newInfluxStub(t, http.StatusNoContent, &requests)
+			defer server.Close()
+			config := testInfluxConfig(server)
+			config.InfluxDBVersion = 1
+			config.SpoolDir = t.TempDir()
+			config.spoolMaxSize = 1 << 20
+			config.Precision = test.spoolPrecision
+			_, err := spoolPayload(config, []byte("afkstatus,hostname=laptop status=\"afk\" 1741944413\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Precision = test.replayPrecision
+			replaySpool(t.Context(), server.Client(), config)
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			if precision := requests[0].query.Get("precision"); precision != test.want {
+				t.Errorf("replayed with precision=%s, want %s", precision, test.want)
+			}
+			if files, _ := os.ReadDir(config.SpoolDir); len(files) != 0 {
+				t.Errorf("%d files left in the spool directory after replaying", len(files))
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// The tags and fields of every point are stored as JSON, the tags shared by
+// the event types are also exposed as columns so the database can be queried
+// directly, for example:
+//
+//	SELECT app, sum(duration) / 3600 FROM points
+//	WHERE measurement = 'currentwindow' AND time >= date('now', '-7 days')
+//	GROUP BY app ORDER BY 2 DESC;
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS points (
+	time TEXT NOT NULL,
+	measurement TEXT NOT NULL,
+	bucket_id TEXT,
+	event_id INTEGER,
+	tags TEXT NOT NULL,
+	fields TEXT NOT NULL,
+	hostname TEXT GENERATED ALWAYS AS (tags ->> 'hostname'),
+	client TEXT GENERATED ALWAYS AS (tags ->> 'client'),
+	app TEXT GENERATED ALWAYS AS (tags ->> 'app'),
+	url TEXT GENERATED ALWAYS AS (tags ->> 'url'),
+	domain TEXT GENERATED ALWAYS AS (tags ->> 'domain'),
+	project TEXT GENERATED ALWAYS AS (tags ->> 'project'),
+	language TEXT GENERATED ALWAYS AS (tags ->> 'language'),
+	file TEXT GENERATED ALWAYS AS (tags ->> 'file'),
+	label TEXT GENERATED ALWAYS AS (tags ->> 'label'),
+	duration REAL GENERATED ALWAYS AS (fields ->> 'duration'),
+	status TEXT GENERATED ALWAYS AS (fields ->> 'status')
+);
+CREATE UNIQUE INDEX IF NOT EXISTS points_event ON points (bucket_id, event_id) WHERE event_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS points_point ON points (measurement, time, tags) WHERE event_id IS NULL;
+CREATE INDEX IF NOT EXISTS points_time ON points (time);
+`
+
+const sqliteInsert = `INSERT OR REPLACE INTO points (time, measurement, bucket_id, event_id, tags, fields) VALUES (?, ?, ?, ?, ?, ?)`
+
+const sqliteTimeLayout = "2006-01-02T15:04:05.000Z"
+
+func openSQLite(ctx context.Context, config Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", config.Output)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the SQLite database: %w", err)
+	}
+	_, err = db.ExecContext(ctx, sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating the SQLite schema: %w", err)
+	}
+	return db, nil
+}
+
+func initSQLiteSchema(ctx context.Context, config Config) error {
+	db, err := openSQLite(ctx, config)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func writeSQLiteBatch(ctx context.Context, db *sql.DB, points []Point) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insert, err := tx.PrepareContext(ctx, sqliteInsert)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, point := range points {
+		tags, err := json.Marshal(pointTagsMap(point))
+		if err != nil {
+			return err
+		}
+		fields, err := json.Marshal(pointFieldsMap(point))
+		if err != nil {
+			return err
+		}
+		var bucket, eventID any
+		if point.EventID != 0 {
+			bucket = point.BucketID
+			eventID = point.EventID
+		}
+		_, err = insert.ExecContext(ctx, point.Time.UTC().Format(sqliteTimeLayout), point.Measurement, bucket, eventID, string(tags), string(fields))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func writeSQLite(ctx context.Context, config Config, points []Point) error {
+	db, err := openSQLite(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for start := 0; start < len(points); start += config.BatchSize {
+		err = writeSQLiteBatch(ctx, db, points[start:min(start+config.BatchSize, len(points))])
+		if err != nil {
+			return fmt.Errorf("error writing to the SQLite database: %w", err)
+		}
+	}
+	debugf("Wrote %d points to %s\n", len(points), config.Output)
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const sshScheme = "ssh"
+
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+func sshAuthMethods(config Config, home string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			debugf("Unable to connect to the ssh-agent: %s", err)
+		} else {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	keyFiles := []string{config.SSHKeyFile}
+	if config.SSHKeyFile == "" {
+		keyFiles = nil
+		for _, name := range defaultSSHKeys {
+			keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, keyFile := range keyFiles {
+		key, err := os.ReadFile(keyFile)
+		if os.IsNotExist(err) && config.SSHKeyFile == "" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSHKeyFile: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		var passphraseErr *ssh.PassphraseMissingError
+		if errors.As(err, &passphraseErr) {
+			debugf("Skipping the encrypted SSH key %s, load it in the ssh-agent to use it", keyFile)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the SSH key %s: %w", keyFile, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH key found, set SSHKeyFile or start an ssh-agent")
+	}
+	return methods, nil
+}
+
+func openSSHTunnel(config Config, sshUrl *url.URL) (*ssh.Client, error) {
+	home, err := os.UserHomeDir()
+	if err != nil && (config.SSHKeyFile == "" || config.SSHKnownHostsFile == "") {
+		return nil, fmt.Errorf("unable to find the default SSH files: %w", err)
+	}
+	username := sshUrl.User.Username()
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the SSH user, add it to ActivityWatchUrl: %w", err)
+		}
+		username = current.Username
+	}
+	auth, err := sshAuthMethods(config, home)
+	if err != nil {
+		return nil, err
+	}
+	knownHostsFile := config.SSHKnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SSHKnownHostsFile: %w", err)
+	}
+	address := sshUrl.Host
+	if sshUrl.Port() == "" {
+		address = net.JoinHostPort(sshUrl.Hostname(), "22")
+	}
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	var keyErr *knownhosts.KeyError
+	switch {
+	case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+		return nil, fmt.Errorf("the host key of the SSH server %s is not in %s, connect once with ssh to add it", address, knownHostsFile)
+	case errors.As(err, &keyErr):
+		return nil, fmt.Errorf("the host key of the SSH server %s does not match the one in %s", address, knownHostsFile)
+	case err != nil && strings.Contains(err.Error(), "unable to authenticate"):
+		return nil, fmt.Errorf("SSH authentication to %s as %s failed, check SSHKeyFile or the keys of the ssh-agent: %w", address, username, err)
+	case err != nil:
+		return nil, fmt.Errorf("unable to connect to the SSH server %s: %w", address, err)
+	}
+	conn, err := client.Dial("tcp", config.SSHRemoteAddress)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connected to the SSH server %s but the ActivityWatch server at %s is unreachable from it: %w", address, config.SSHRemoteAddress, err)
+	}
+	conn.Close()
+	debugf("Opened an SSH tunnel to %s through %s as %s", config.SSHRemoteAddress, address, username)
+	return client, nil
+}
+
+func sshDialContext(client *ssh.Client, address string) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if addr != address {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return client.DialContext(ctx, network, addr)
+	}
+}
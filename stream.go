@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// PointWriter writes the points of every chunk as they are streamed, Close writes the rest
+// and returns the bytes written
+type PointWriter interface {
+	Write(ctx context.Context, points []Point)
+	Close(ctx context.Context) (int, error)
+}
+
+func newStreamWriter(client *http.Client, config Config) (PointWriter, error) {
+	if config.Format == "" {
+		return newInfluxBatchWriter(client, config), nil
+	}
+	file, err := openOutput(config.Output)
+	if err != nil {
+		return nil, err
+	}
+	return newOutputWriter(config, file), nil
+}
+
+type StreamResult struct {
+	Points         int
+	Written        int
+	BucketExported map[string]int
+}
+
+// streamBuckets fetches the buckets and passes the points of every chunk of events to the writer
+// as soon as it is fetched, so the memory used depends on ChunkSize and BatchSize instead of the
+// number of exported days. The rollups and the extra points are written once every bucket is
+// fetched, the rollups only when none of them failed, a nil writer only counts the points for the dry runs
+func streamBuckets(ctx context.Context, writeCtx context.Context, client *http.Client, config Config, entries []Bucket, period Period, rollups *Rollups, extra []Point, summary *Summary, apiErrors *atomic.Int64, writer PointWriter) (StreamResult, error) {
+	result := StreamResult{BucketExported: make(map[string]int)}
+	fetchErrors := apiErrors.Load()
+	// the writer goroutine owns the pending batch, the buffer lets the workers fetch the next chunk meanwhile
+	batches := make(chan []Point, config.concurrency)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for points := range batches {
+			result.Points += len(points)
+			if writer != nil {
+				writer.Write(writeCtx, points)
+			}
+		}
+	}()
+	mu := &sync.Mutex{}
+	fetchErr := fetchBuckets(ctx, client, config, entries, nil, period, summary, apiErrors, func(entry Bucket, events []Event) {
+		mu.Lock()
+		rollups.AddBucket(config, entry, events)
+		mu.Unlock()
+		if config.SkipRawEvents {
+			return
+		}
+		entryPoints := bucketPoints(config, entry, events, summary)
+		sortPoints(entryPoints)
+		mu.Lock()
+		result.BucketExported[entry.ID] += len(entryPoints)
+		mu.Unlock()
+		batches <- entryPoints
+	})
+	// the rollups of a failed, aborted or timed out fetch are incomplete and would overwrite complete totals
+	if fetchErr == nil && ctx.Err() == nil && apiErrors.Load() == fetchErrors {
+		batches <- rollups.Points(config)
+	} else if len(config.Rollups) > 0 {
+		log.Println("Skipping the rollups of the incomplete fetch")
+	}
+	if fetchErr == nil && writeCtx.Err() == nil {
+		batches <- extra
+	}
+	close(batches)
+	<-done
+	if writer == nil {
+		return result, fetchErr
+	}
+	written, err := writer.Close(writeCtx)
+	result.Written = written
+	return result, errors.Join(fetchErr, err)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamBuckets(t *testing.T) {
+	entries := testBuckets(2)
+	period := Period{Start: testTime.Add(-72 * time.Hour), End: testTime}
+	stub := &activityWatchStub{events: make(map[string][]Event), delay: 20 * time.Millisecond}
+	for _, entry := range entries {
+		for i := range 60 {
+			data := fmt.Sprintf(`{"app":"app %d","title":"title %d"}`, i%3, i)
+			stub.events[entry.ID] = append(stub.events[entry.ID], Event{ID: i + 1, Timestamp: period.Start.Add(time.Duration(i) * 72 * time.Minute), Duration: 30, Data: json.RawMessage(data)})
+		}
+	}
+	tests := []struct {
+		name      string
+		batchSize int
+		rollups   []string
+		skipRaw   bool
+		dryRun    bool
+	}{
+		{"batches", 10, nil, false, false},
+		{"batch larger than a chunk", 50, nil, false, false},
+		{"rollups", 10, []string{rollupAppDaily}, false, false},
+		{"only rollups", 10, []string{rollupAppDaily}, true, false},
+		{"dry run", 10, nil, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mu := &sync.Mutex{}
+			var requests []string
+			var batches [][]string
+			aw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, "events")
+				mu.Unlock()
+				stub.ServeHTTP(w, r)
+			}))
+			defer aw.Close()
+			influx := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reader, err := gzip.NewReader(r.Body)
+				if err != nil {
+					t.Errorf("error reading the gzip body: %s", err)
+					return
+				}
+				data, _ := io.ReadAll(reader)
+				mu.Lock()
+				requests = append(requests, "write")
+				batches = append(batches, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer influx.Close()
+			config := testInfluxConfig(influx)
+			config.ActivityWatchUrl = aw.URL
+			config.PageSize = 100
+			config.chunkSize = 24 * time.Hour
+			config.eventsTimeout = 5 * time.Second
+			config.concurrency = 1
+			config.location = time.UTC
+			config.StreamBatches = true
+			config.BatchSize = test.batchSize
+			config.Rollups = test.rollups
+			config.SkipRawEvents = test.skipRaw
+			extra := []Point{{Measurement: "aw_server", Fields: []Field{{Key: "up", Value: true}}, Time: testTime}}
+
+			var want []string
+			rollups := newRollups(test.rollups, period.Start)
+			for _, entry := range entries {
+				rollups.AddBucket(config, entry, stub.events[entry.ID])
+				if !test.skipRaw {
+					for _, point := range bucketPoints(config, entry, stub.events[entry.ID], &Summary{}) {
+						want = append(want, strings.TrimSuffix(point.LineProtocol(config.precision), "\n"))
+					}
+				}
+			}
+			for _, point := range append(rollups.Points(config), extra...) {
+				want = append(want, strings.TrimSuffix(point.LineProtocol(config.precision), "\n"))
+			}
+
+			var apiErrors atomic.Int64
+			var writer PointWriter
+			if !test.dryRun {
+				writer = newInfluxBatchWriter(influx.Client(), config)
+			}
+			result, err := streamBuckets(t.Context(), t.Context(), influx.Client(), config, entries, period, newRollups(test.rollups, period.Start), extra, &Summary{}, &apiErrors, writer)
+			if err != nil || apiErrors.Load() != 0 {
+				t.Fatalf("streamBuckets() = %v with %d errors", err, apiErrors.Load())
+			}
+			if result.Points != len(want) {
+				t.Errorf("streamed %d points, want %d", result.Points, len(want))
+			}
+			if test.dryRun {
+				if len(batches) != 0 {
+					t.Errorf("wrote %d batches in a dry run", len(batches))
+				}
+				return
+			}
+			var got []string
+			for i, batch := range batches {
+				if len(batch) > test.batchSize {
+					t.Errorf("batch %d has %d lines, want at most %d", i, len(batch), test.batchSize)
+				}
+				if i < len(batches)-1 && len(batch) != test.batchSize {
+					t.Errorf("batch %d of %d has %d lines, want %d", i, len(batches), len(batch), test.batchSize)
+				}
+				got = append(got, batch...)
+			}
+			slices.Sort(got)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("wrote %d lines different from the %d lines of the whole export", len(got), len(want))
+			}
+			// every bucket has 3 chunks of 20 events, the first batch is full before the last chunk is fetched
+			if before := slices.Index(requests, "write"); !test.skipRaw && before >= len(entries)*3 {
+				t.Errorf("first write after %d of %d events requests, want the batches written while fetching", before, len(entries)*3)
+			}
+		})
+	}
+}
+
+type loggedFile struct {
+	bytes.Buffer
+	mu       *sync.Mutex
+	requests *[]string
+}
+
+func (file *loggedFile) Write(p []byte) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	*file.requests = append(*file.requests, "write")
+	return file.Buffer.Write(p)
+}
+
+func (file *loggedFile) Close() error {
+	return nil
+}
+
+func TestStreamBucketsOutput(t *testing.T) {
+	entries := testBuckets(2)
+	period := Period{Start: testTime.Add(-72 * time.Hour), End: testTime}
+	stub := &activityWatchStub{events: make(map[string][]Event), delay: 20 * time.Millisecond}
+	for _, entry := range entries {
+		for i := range 60 {
+			data := fmt.Sprintf(`{"app":"app %d","title":"a long window title to fill the output buffer, number %d"}`, i%3, i)
+			stub.events[entry.ID] = append(stub.events[entry.ID], Event{ID: i + 1, Timestamp: period.Start.Add(time.Duration(i) * 72 * time.Minute), Duration: 30, Data: json.RawMessage(data)})
+		}
+	}
+	tests := []struct {
+		format string
+		lines  int
+	}{
+		{formatCSV, 121},
+		{formatJSONL, 120},
+		{formatLineProtocol, 120},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			mu := &sync.Mutex{}
+			var requests []string
+			aw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, "events")
+				mu.Unlock()
+				stub.ServeHTTP(w, r)
+			}))
+			defer aw.Close()
+			config := testFetchConfig(aw.URL)
+			config.concurrency = 1
+			config.StreamBatches = true
+			config.Format = test.format
+			config.precision = time.Second
+			file := &loggedFile{mu: mu, requests: &requests}
+			var apiErrors atomic.Int64
+			result, err := streamBuckets(t.Context(), t.Context(), aw.Client(), config, entries, period, newRollups(nil, period.Start), nil, &Summary{}, &apiErrors, newOutputWriter(config, file))
+			if err != nil || apiErrors.Load() != 0 {
+				t.Fatalf("streamBuckets() = %v with %d errors", err, apiErrors.Load())
+			}
+			if lines := strings.Count(file.String(), "\n"); lines != test.lines || result.Written != file.Len() {
+				t.Errorf("wrote %d lines and %d bytes, want %d lines and %d bytes", lines, result.Written, test.lines, file.Len())
+			}
+			if before := slices.Index(requests, "write"); before >= len(entries)*3 {
+				t.Errorf("first write after %d of %d events requests, want the rows written while fetching", before, len(entries)*3)
+			}
+		})
+	}
+}
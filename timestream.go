@@ -0,0 +1,101 @@
+//go:build timestream
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/timestreamwrite"
+	"github.com/aws/aws-sdk-go-v2/service/timestreamwrite/types"
+)
+
+const timestreamEnabled = true
+
+const timestreamBatchSize = 100
+
+func timestreamRecord(point Point, version int64) (types.Record, bool) {
+	record := types.Record{
+		MeasureName:      aws.String(point.Measurement),
+		MeasureValueType: types.MeasureValueTypeMulti,
+		Time:             aws.String(strconv.FormatInt(point.Time.UnixMilli(), 10)),
+		TimeUnit:         types.TimeUnitMilliseconds,
+		Version:          aws.Int64(version),
+	}
+	for _, tag := range point.Tags {
+		record.Dimensions = append(record.Dimensions, types.Dimension{Name: aws.String(tag.Key), Value: aws.String(tag.Value)})
+	}
+	for _, field := range point.Fields {
+		measure := types.MeasureValue{Name: aws.String(field.Key)}
+		switch v := field.Value.(type) {
+		case float64:
+			measure.Type, measure.Value = types.MeasureValueTypeDouble, aws.String(strconv.FormatFloat(v, 'f', -1, 64))
+		case int64:
+			measure.Type, measure.Value = types.MeasureValueTypeBigint, aws.String(strconv.FormatInt(v, 10))
+		case int:
+			measure.Type, measure.Value = types.MeasureValueTypeBigint, aws.String(strconv.Itoa(v))
+		case bool:
+			measure.Type, measure.Value = types.MeasureValueTypeBoolean, aws.String(strconv.FormatBool(v))
+		case string:
+			if v == "" {
+				continue
+			}
+			measure.Type, measure.Value = types.MeasureValueTypeVarchar, aws.String(v)
+		default:
+			continue
+		}
+		record.MeasureValues = append(record.MeasureValues, measure)
+	}
+	return record, len(record.MeasureValues) > 0
+}
+
+func writeTimestream(ctx context.Context, config Config, points []Point) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	options := []func(*awsconfig.LoadOptions) error{}
+	if config.TimestreamRegion != "" {
+		options = append(options, awsconfig.WithRegion(config.TimestreamRegion))
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return fmt.Errorf("error loading the AWS configuration: %w", err)
+	}
+	client := timestreamwrite.NewFromConfig(awsConfig)
+	version := time.Now().UnixMilli()
+	var records []types.Record
+	for _, point := range points {
+		if record, ok := timestreamRecord(point, version); ok {
+			records = append(records, record)
+		}
+	}
+	var rejected int
+	for start := 0; start < len(records); start += timestreamBatchSize {
+		_, err = client.WriteRecords(ctx, &timestreamwrite.WriteRecordsInput{
+			DatabaseName: aws.String(config.TimestreamDatabase),
+			TableName:    aws.String(config.TimestreamTable),
+			Records:      records[start:min(start+timestreamBatchSize, len(records))],
+		})
+		var rejectedErr *types.RejectedRecordsException
+		if errors.As(err, &rejectedErr) {
+			for _, record := range rejectedErr.RejectedRecords {
+				log.Printf("Timestream rejected record %d: %s\n", start+int(record.RecordIndex), aws.ToString(record.Reason))
+			}
+			rejected += len(rejectedErr.RejectedRecords)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error sending data: %w", err)
+		}
+	}
+	if rejected > 0 {
+		return fmt.Errorf("%d of %d records rejected by Timestream", rejected, len(records))
+	}
+	debugf("Wrote %d records to Timestream\n", len(records))
+	return nil
+}
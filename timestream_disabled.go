@@ -0,0 +1,14 @@
+//go:build !timestream
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+const timestreamEnabled = false
+
+func writeTimestream(ctx context.Context, config Config, points []Point) error {
+	return errors.New("built without Timestream support")
+}
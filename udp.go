@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+)
+
+const udpScheme = "udp"
+
+func writeUDP(ctx context.Context, config Config, payload []byte) error {
+	target, _ := url.Parse(config.WriteURL)
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", target.Host)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", target.Host, err)
+	}
+	defer conn.Close()
+	var datagrams int
+	for _, datagram := range socketChunks(payload, config.UDPMaxDatagramSize) {
+		if len(datagram) > config.UDPMaxDatagramSize {
+			log.Printf("Warning: sending a line of %d bytes, bigger than UDPMaxDatagramSize, in a single datagram\n", len(datagram))
+		}
+		_, err = conn.Write(datagram)
+		if err != nil {
+			return fmt.Errorf("error sending data: %w", err)
+		}
+		datagrams++
+	}
+	log.Printf("Sent %d datagrams to %s\n", datagrams, target.Host)
+	return nil
+}
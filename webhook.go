@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+func writeWebhookBatch(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	body, err := json.Marshal(points)
+	if err != nil {
+		return 0, err
+	}
+	if len(body) > config.WebhookMaxBytes {
+		if len(points) == 1 {
+			return 0, fmt.Errorf("error sending data: a point of %d bytes is bigger than WebhookMaxBytes", len(body))
+		}
+		half := len(points) / 2
+		written, err := writeWebhookBatch(ctx, client, config, points[:half])
+		if err != nil {
+			return written, err
+		}
+		n, err := writeWebhookBatch(ctx, client, config, points[half:])
+		return written + n, err
+	}
+	return len(body), postWebhook(ctx, client, config, body)
+}
+
+func writeWebhook(ctx context.Context, client *http.Client, config Config, points []Point) (int, error) {
+	var written int
+	for start := 0; start < len(points); start += config.BatchSize {
+		n, err := writeWebhookBatch(ctx, client, config, points[start:min(start+config.BatchSize, len(points))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func webhookHost(config Config) string {
+	if config.Backend != backendWebhook {
+		return ""
+	}
+	webhookUrl, err := url.Parse(config.WebhookUrl)
+	if err != nil {
+		return ""
+	}
+	return webhookUrl.Host
+}
+
+func postWebhook(ctx context.Context, client *http.Client, config Config, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, config.writeTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", config.WebhookUrl, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range config.WebhookHeaders {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := readBody(resp.Body, config.maxResponseSize)
+	if err != nil {
+		return fmt.Errorf("error reading data: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error sending data: %s: %s", resp.Status, apiErrorMessage(respBody))
+	}
+	return nil
+}